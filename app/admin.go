@@ -0,0 +1,224 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// userKey is the context key of the signed in admin user.
+type userKey struct{}
+
+// requestUser returns the admin user who is signed in, in the admin pages.
+func requestUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userKey{}).(*User)
+	return user
+}
+
+// adminOnly allows the requests of the signed in admin users, whose sessions were
+// not revoked. Other page requests are redirected to the login page, and other
+// requests are forbidden.
+func (s *Server) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := s.sessionUser(r)
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+
+		if user == nil {
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	})
+}
+
+// sessionUser returns the user of the session of the request, or nil when the request
+// has no session, or its session was revoked.
+func (s *Server) sessionUser(r *http.Request) (*User, error) {
+	session, ok := s.Sessions.Session(r)
+	if !ok {
+		return nil, nil
+	}
+	user, err := s.DB.GetUser(r.Context(), session.Username)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.SessionVersion != session.Version {
+		return nil, nil
+	}
+	revoked, err := s.DB.IsSessionRevoked(r.Context(), session.ID)
+	if err != nil || revoked {
+		return nil, err
+	}
+	return user, nil
+}
+
+// csrfProtect rejects the form submissions which do not have the CSRF token of the request.
+func (s *Server) csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !s.Sessions.CheckCSRF(r) {
+				http.Error(w, "Invalid CSRF token, reload the page and try again", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminContext is the template data shared by the admin pages.
+func (s *Server) adminContext(w http.ResponseWriter, r *http.Request) TemplateContext {
+	return TemplateContext{
+		Topic:     GetDefaultTopic(),
+		Version:   Version,
+		User:      requestUser(r),
+		CSRFToken: s.Sessions.CSRFToken(w, r),
+	}
+}
+
+// safeNext returns the page to redirect to after signing in. Only admin pages
+// are allowed, so that the login page cannot redirect to another site.
+func safeNext(next string) string {
+	if next == "/admin" || strings.HasPrefix(next, "/admin/") || strings.HasPrefix(next, "/admin?") {
+		return next
+	}
+	return "/admin"
+}
+
+func (s *Server) loginFormHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderLogin(w, r, http.StatusOK, "")
+}
+
+// renderLogin renders the login page, with the error of the form.
+func (s *Server) renderLogin(w http.ResponseWriter, r *http.Request, statusCode int, formError string) {
+	next := r.URL.Query().Get("next")
+	if r.Method == http.MethodPost {
+		next = r.PostFormValue("next")
+	}
+
+	data := TemplateContext{
+		Topic:     GetDefaultTopic(),
+		Version:   Version,
+		CSRFToken: s.Sessions.CSRFToken(w, r),
+		Next:      safeNext(next),
+		Form:      map[string]string{"username": r.PostFormValue("username")},
+		FormError: formError,
+	}
+
+	w.WriteHeader(statusCode)
+	s.Templates.ExecuteTemplate(w, "login", data)
+}
+
+// clientIP is the address of the client of the request, without its port. Behind a proxy,
+// it is read from the header which the proxy sets, ex: "X-Forwarded-For". The proxy appends
+// the address of its client to the header, so only the last address can be trusted.
+func clientIP(r *http.Request, header string) string {
+	if header != "" {
+		addrs := strings.Split(strings.Join(r.Header.Values(header), ","), ",")
+		if ip := net.ParseIP(strings.TrimSpace(addrs[len(addrs)-1])); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
+	username, client := r.PostFormValue("username"), clientIP(r, s.ClientIPHeader)
+	if !s.Logins.Allowed(client) {
+		s.renderLogin(w, r, http.StatusTooManyRequests, "Too many failed sign-ins, try again later")
+		return
+	}
+	if delay := s.Logins.Delay(username); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	user, err := Authenticate(r.Context(), s.DB, username, r.PostFormValue("password"))
+	if errors.Is(err, ErrUserNotFound) {
+		s.Logins.Failed(username, client)
+		s.renderLogin(w, r, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
+
+	s.Logins.Succeeded(username)
+	s.Sessions.Login(w, r, user)
+	http.Redirect(w, r, safeNext(r.PostFormValue("next")), http.StatusSeeOther)
+}
+
+// logoutHandler ends the session. The session is revoked, so its cookie cannot
+// be used again, and the other sessions of the user continue.
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if session, ok := s.Sessions.Session(r); ok {
+		if err := s.DB.RevokeSession(r.Context(), session.ID, session.ExpiresAt); err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+	}
+
+	s.Sessions.Logout(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// logoutEverywhereHandler ends the sessions of the user, on every device.
+func (s *Server) logoutEverywhereHandler(w http.ResponseWriter, r *http.Request) {
+	if session, ok := s.Sessions.Session(r); ok {
+		err := s.DB.RevokeSessions(r.Context(), session.Username)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			s.serverError(w, r, err)
+			return
+		}
+	}
+
+	s.Sessions.Logout(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (s *Server) adminIndexHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/admin/fetch", http.StatusSeeOther)
+}
+
+func (s *Server) adminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	// Fetch tasklog.
+	tasklog, err := s.DB.GetRecentTaskLog(r.Context(), TaskUpdateArticles)
+	if err != nil {
+		tasklog = &TaskLog{}
+	}
+
+	// Prepare the template data.
+	data := s.adminContext(w, r)
+	data.LastSync = tasklog.CompletedAtDisplay()
+	data.Jobs = Jobs()
+	if s.Scheduler != nil {
+		data.Jobs = s.Scheduler.Jobs()
+	}
+
+	s.Templates.ExecuteTemplate(w, "admin_jobs", data)
+}
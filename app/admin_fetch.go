@@ -0,0 +1,268 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AdminFetchMaxDays is the longest date range of a fetch started from the admin pages.
+// Longer ranges should be fetched with the 'backfill' command.
+const AdminFetchMaxDays = 31
+
+// fetchRunsKept is the number of finished runs which are listed on the admin pages.
+const fetchRunsKept = 20
+
+// FetchRun is a fetch of the articles of a topic, started from the admin pages.
+type FetchRun struct {
+	ID         int       `json:"id"`
+	Topic      string    `json:"topic"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Progress   []string  `json:"progress"`
+	TaskLog    *TaskLog  `json:"tasklog"`
+	TaskLogID  int       `json:"tasklog_id,omitempty"` // The id of the recorded task log, 0 when it was not recorded.
+	Error      string    `json:"error,omitempty"`
+}
+
+// Running reports whether the run has not finished.
+func (f *FetchRun) Running() bool {
+	return f.FinishedAt.IsZero()
+}
+
+// Status of the run, ex: "running", or the status of its task log.
+func (f *FetchRun) Status() string {
+	switch {
+	case f.Running():
+		return "running"
+	case f.TaskLog != nil:
+		return f.TaskLog.Status
+	default:
+		return TaskStatusFailed
+	}
+}
+
+// FetchRunner runs the fetches started from the admin pages in the background.
+// The runs share the lease of UpdateArticles with the scheduled jobs.
+type FetchRunner struct {
+	db      Database
+	timeout time.Duration
+
+	mu     sync.Mutex
+	runs   []*FetchRun
+	nextID int
+	wg     sync.WaitGroup
+}
+
+// NewFetchRunner creates a FetchRunner. The runs are cancelled after the timeout of the fetch job.
+func NewFetchRunner(db Database) *FetchRunner {
+	runner := &FetchRunner{db: db, nextID: 1}
+	if job, ok := GetJob(JobFetchArticles); ok {
+		runner.timeout = job.Timeout
+	}
+	return runner
+}
+
+// Start fetches the articles of the topic in the background, and returns the run.
+func (f *FetchRunner) Start(topic *Topic, from, to time.Time) *FetchRun {
+	f.mu.Lock()
+	run := &FetchRun{
+		ID:        f.nextID,
+		Topic:     topic.Slug,
+		From:      from,
+		To:        to,
+		StartedAt: time.Now().UTC(),
+		Progress:  []string{},
+	}
+	f.nextID++
+	f.runs = append([]*FetchRun{run}, f.runs...)
+	if len(f.runs) > fetchRunsKept {
+		f.runs = f.runs[:fetchRunsKept]
+	}
+	snapshot := *run
+	f.mu.Unlock()
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.run(run, topic)
+	}()
+	return &snapshot
+}
+
+func (f *FetchRunner) run(run *FetchRun, topic *Topic) {
+	ctx := WithProgress(context.Background(), func(message string) {
+		f.mu.Lock()
+		run.Progress = append(run.Progress, message)
+		f.mu.Unlock()
+	})
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	result, err := UpdateArticles(ctx, f.db, topic, run.From, run.To, true)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	run.FinishedAt = time.Now().UTC()
+	switch {
+	case result != nil:
+		run.TaskLog = result.TaskLog(true)
+		run.TaskLogID = result.TaskLogID
+	case errors.Is(err, ErrTaskLocked):
+		run.TaskLog = &TaskLog{Task: TaskUpdateArticles, Manual: true, Status: TaskStatusSkipped, Error: err.Error()}
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+}
+
+// Wait blocks until the started runs have finished.
+func (f *FetchRunner) Wait() {
+	f.wg.Wait()
+}
+
+// Get returns a copy of the run with the id.
+func (f *FetchRunner) Get(id int) (*FetchRun, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, run := range f.runs {
+		if run.ID == id {
+			return run.copy(), true
+		}
+	}
+	return nil, false
+}
+
+// Runs returns a copy of the recent runs, the most recent first.
+func (f *FetchRunner) Runs() []*FetchRun {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	runs := []*FetchRun{}
+	for _, run := range f.runs {
+		runs = append(runs, run.copy())
+	}
+	return runs
+}
+
+// copy is a snapshot of the run, which is safe to read while the run continues.
+func (f *FetchRun) copy() *FetchRun {
+	run := *f
+	run.Progress = append([]string{}, f.Progress...)
+	return &run
+}
+
+// parseFetchForm reads the topic and the date range of a fetch from the form.
+func parseFetchForm(r *http.Request) (*Topic, time.Time, time.Time, error) {
+	topic, ok := GetTopic(r.PostFormValue("topic"))
+	if !ok {
+		return nil, time.Time{}, time.Time{}, fmt.Errorf("unknown topic %q", r.PostFormValue("topic"))
+	}
+	from, err := time.Parse("2006-01-02", r.PostFormValue("from"))
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, fmt.Errorf("invalid 'from' date, expected YYYY-MM-DD")
+	}
+	to, err := time.Parse("2006-01-02", r.PostFormValue("to"))
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, fmt.Errorf("invalid 'to' date, expected YYYY-MM-DD")
+	}
+	if from.After(to) {
+		return nil, time.Time{}, time.Time{}, fmt.Errorf("the 'from' date is after the 'to' date")
+	}
+	if to.Sub(from) >= AdminFetchMaxDays*24*time.Hour {
+		return nil, time.Time{}, time.Time{}, fmt.Errorf("the date range is longer than %v days, use the backfill command", AdminFetchMaxDays)
+	}
+	return topic, from, to, nil
+}
+
+func (s *Server) adminFetchHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderAdminFetch(w, r, http.StatusOK, "")
+}
+
+// renderAdminFetch renders the fetch form and the recent runs, with the error of the form.
+func (s *Server) renderAdminFetch(w http.ResponseWriter, r *http.Request, statusCode int, formError string) {
+	today := time.Now().UTC().Format("2006-01-02")
+	form := map[string]string{"topic": GetDefaultTopic().Slug, "from": today, "to": today}
+	if r.Method == http.MethodPost {
+		for key := range form {
+			form[key] = r.PostFormValue(key)
+		}
+	}
+
+	data := s.adminContext(w, r)
+	data.Topics = GetTopics()
+	data.FetchRuns = s.Fetches.Runs()
+	data.Form = form
+	data.FormError = formError
+
+	w.WriteHeader(statusCode)
+	s.Templates.ExecuteTemplate(w, "admin_fetch", data)
+}
+
+func (s *Server) adminStartFetchHandler(w http.ResponseWriter, r *http.Request) {
+	topic, from, to, err := parseFetchForm(r)
+	if err != nil {
+		s.renderAdminFetch(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	run := s.Fetches.Start(topic, from, to)
+	http.Redirect(w, r, fmt.Sprintf("/admin/fetch/%v", run.ID), http.StatusSeeOther)
+}
+
+// requestFetchRun returns the run of the 'id' route var.
+func (s *Server) requestFetchRun(r *http.Request) (*FetchRun, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, false
+	}
+	return s.Fetches.Get(id)
+}
+
+func (s *Server) adminFetchRunHandler(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.requestFetchRun(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The page shows the recorded task log of a finished run.
+	if run.TaskLogID != 0 {
+		tasklog, err := s.DB.GetTaskLog(r.Context(), run.TaskLogID)
+		if err != nil {
+			s.serverError(w, r, err)
+			return
+		}
+		run.TaskLog = tasklog
+	}
+
+	data := s.adminContext(w, r)
+	data.FetchRun = run
+
+	s.Templates.ExecuteTemplate(w, "admin_fetch_run", data)
+}
+
+// adminFetchRunJSONHandler reports the progress of a run, for the live updates of the run page.
+func (s *Server) adminFetchRunJSONHandler(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.requestFetchRun(r)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, apiErrorResponse{Error: "unknown fetch run"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"run":     run,
+		"status":  run.Status(),
+		"running": run.Running(),
+	})
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+
+func TestAdminStartFetchHandler(t *testing.T) {
+	is := is.New(t)
+	s := newTestAdminServer(t)
+
+	form := url.Values{"topic": {"daca"}, "from": {"2020-08-01"}, "to": {"2020-08-03"}}
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, adminRequest(s, "POST", "/admin/fetch", form))
+	is.Equal(w.Code, http.StatusSeeOther)
+	is.Equal(w.Header().Get("Location"), "/admin/fetch/1")
+
+	s.Fetches.Wait()
+	run, ok := s.Fetches.Get(1)
+	is.True(ok)
+	is.Equal(run.Status(), TaskStatusSuccess)
+	is.Equal(run.TaskLog.Inserted, 1)
+	is.True(run.TaskLog.Manual)
+	is.True(run.TaskLogID > 0)
+	is.True(len(run.Progress) > 0) // The progress of the run is reported
+
+	// The run page shows the recorded task log, not the copy in memory.
+	s.DB.(*ServerDB).db.MustExec(`UPDATE tasklog SET inserted = 2 WHERE id = ?;`, run.TaskLogID)
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, adminRequest(s, "GET", "/admin/fetch/1", nil))
+	is.Equal(w.Code, http.StatusOK)
+	doc := goqueryDoc(w.Body)
+	is.Equal(doc.Find(`[data-field="status"]`).Text(), TaskStatusSuccess)
+	is.Equal(doc.Find(`[data-field="inserted"]`).Text(), "2")
+
+	// The progress is polled as JSON.
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, adminRequest(s, "GET", "/admin/fetch/1.json", nil))
+	is.Equal(w.Code, http.StatusOK)
+	progress := struct {
+		Status  string `json:"status"`
+		Running bool   `json:"running"`
+	}{}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&progress))
+	is.Equal(progress.Status, TaskStatusSuccess)
+	is.True(!progress.Running)
+
+	// The run is recorded in the tasklog.
+	tasklog, err := s.DB.GetRecentTaskLog(context.Background(), TaskUpdateArticles)
+	is.NoErr(err)
+	is.True(tasklog.Manual)
+
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, adminRequest(s, "GET", "/admin/fetch/2", nil))
+	is.Equal(w.Code, http.StatusNotFound) // Unknown run
+}
+
+func TestAdminStartFetchHandler_InvalidForm(t *testing.T) {
+	is := is.New(t)
+	s := newTestAdminServer(t)
+
+	tests := []url.Values{
+		{"topic": {"asylum"}, "from": {"2020-08-01"}, "to": {"2020-08-03"}},
+		{"topic": {"daca"}, "from": {"08/01/2020"}, "to": {"2020-08-03"}},
+		{"topic": {"daca"}, "from": {"2020-08-03"}, "to": {"2020-08-01"}},
+		{"topic": {"daca"}, "from": {"2020-01-01"}, "to": {"2020-08-01"}},
+	}
+
+	for _, form := range tests {
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, adminRequest(s, "POST", "/admin/fetch", form))
+		is.Equal(w.Code, http.StatusBadRequest)
+		doc := goqueryDoc(w.Body)
+		is.True(doc.Find(".app-form-error").Text() != "") // The error is shown
+	}
+	is.Equal(len(s.Fetches.Runs()), 0) // No fetch was started
+}
+
+func TestAdminStartFetchHandler_Locked(t *testing.T) {
+	is := is.New(t)
+	s := newTestAdminServer(t)
+
+	// A scheduled run holds the lease.
+	lease, err := AcquireTaskLease(context.Background(), s.DB, TaskUpdateArticles, time.Minute)
+	is.NoErr(err)
+	defer ReleaseTaskLease(s.DB, lease)
+
+	run := s.Fetches.Start(DefaultTopic, time.Now().UTC(), time.Now().UTC())
+	s.Fetches.Wait()
+
+	run, _ = s.Fetches.Get(run.ID)
+	is.Equal(run.Status(), TaskStatusSkipped) // The runs do not overlap
+	is.True(strings.Contains(run.Error, lease.Holder))
+}
@@ -0,0 +1,293 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+// Test Helpers
+
+// newTestAdminServer creates a server with a sqlite database, whose sources return
+// a single article, and which has the admin user "admin".
+func newTestAdminServer(t *testing.T) *Server {
+	db := newTestDB(t)
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("could not migrate the database: %v", err)
+	}
+	if _, err := CreateUser(context.Background(), db, "admin", "correct-horse"); err != nil {
+		t.Fatalf("could not create the admin user: %v", err)
+	}
+
+	previous := sourceRegistry
+	SetSources(func() Source {
+		return &fakeSource{name: "fake", articles: []*Article{
+			{URL: "https://example.com/1", Title: "Article", Source: "example", PublishedAt: time.Now().UTC()},
+		}}
+	})
+	t.Cleanup(func() { sourceRegistry = previous })
+
+	s := newTestServer(db)
+	s.Fetches = NewFetchRunner(db)
+	s.Router = s.GetRouter()
+	return s
+}
+
+// testCSRFToken is the CSRF token of the test requests.
+const testCSRFToken = "test-csrf-token"
+
+// testSessionID is the id of the session of the admin requests.
+const testSessionID = "test-session"
+
+// newFormRequest creates a request with the form, and the CSRF token in the form.
+func newFormRequest(method, target, token string, form url.Values) *http.Request {
+	if form == nil {
+		return httptest.NewRequest(method, target, nil)
+	}
+	values := url.Values{"csrf_token": {token}}
+	for key, value := range form {
+		values[key] = value
+	}
+	r := httptest.NewRequest(method, target, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+// formRequest creates a request with the CSRF cookie, and the form with its CSRF token.
+func formRequest(s *Server, method, target string, form url.Values) *http.Request {
+	token := s.Sessions.sign(testCSRFToken)
+	r := newFormRequest(method, target, token, form)
+	r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: token})
+	return r
+}
+
+// adminRequest creates a request which is signed in as the admin, and the form
+// with the CSRF token of the session.
+func adminRequest(s *Server, method, target string, form url.Values) *http.Request {
+	value := s.Sessions.sessionValue("admin", 0, time.Now().Add(time.Hour), testSessionID)
+	token := s.Sessions.sessionCSRFToken(&Session{Username: "admin", cookie: value})
+	r := newFormRequest(method, target, token, form)
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: value})
+	return r
+}
+
+// ------------------------------------------------------------------
+
+func TestAdminOnly(t *testing.T) {
+	is := is.New(t)
+	s := newTestAdminServer(t)
+
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, httptest.NewRequest("GET", "/admin/fetch", nil))
+	is.Equal(w.Code, http.StatusSeeOther) // Not signed in
+	is.Equal(w.Header().Get("Location"), "/login?next=%2Fadmin%2Ffetch")
+
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, formRequest(s, "POST", "/admin/fetch", url.Values{"topic": {"daca"}}))
+	is.Equal(w.Code, http.StatusForbidden) // Forms are not redirected
+
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, adminRequest(s, "GET", "/admin/fetch", nil))
+	is.Equal(w.Code, http.StatusOK)
+	doc := goqueryDoc(w.Body)
+	is.Equal(doc.Find(`[data-field="user"]`).Text(), "admin") // The user is shown
+
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, adminRequest(s, "GET", "/admin", nil))
+	is.Equal(w.Code, http.StatusSeeOther)
+	is.Equal(w.Header().Get("Location"), "/admin/fetch")
+
+	// The session of a deleted user is not valid.
+	r := httptest.NewRequest("GET", "/admin/jobs", nil)
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: s.Sessions.sessionValue("bob", 0, time.Now().Add(time.Hour), "session-1")})
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusSeeOther)
+
+	// The revoked sessions of a user are not valid.
+	is.NoErr(s.DB.RevokeSessions(context.Background(), "admin"))
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, adminRequest(s, "GET", "/admin/jobs", nil))
+	is.Equal(w.Code, http.StatusSeeOther)
+}
+
+func TestAdminCSRF(t *testing.T) {
+	is := is.New(t)
+	s := newTestAdminServer(t)
+	form := url.Values{"topic": {"daca"}, "from": {"2020-08-01"}, "to": {"2020-08-03"}}
+
+	r := adminRequest(s, "POST", "/admin/fetch", form)
+	r.Form = nil
+	r.PostForm = nil
+	r.Body = ioutil.NopCloser(strings.NewReader(form.Encode()))
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusForbidden) // No CSRF token
+
+	values := url.Values{"csrf_token": {s.Sessions.sign("another-token")}}
+	for key, value := range form {
+		values[key] = value
+	}
+	r = adminRequest(s, "POST", "/admin/fetch", nil)
+	r.Body = ioutil.NopCloser(strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusForbidden) // The token does not match the cookie
+
+	r = adminRequest(s, "POST", "/admin/fetch", nil)
+	token := s.Sessions.sign(testCSRFToken)
+	values.Set("csrf_token", token)
+	r.Body = ioutil.NopCloser(strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: token})
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusForbidden) // A token which is not of the session
+
+	is.Equal(len(s.Fetches.Runs()), 0) // No fetch was started
+}
+
+func TestLoginHandler(t *testing.T) {
+	is := is.New(t)
+	s := newTestAdminServer(t)
+
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, httptest.NewRequest("GET", "/login?next=/admin/jobs", nil))
+	is.Equal(w.Code, http.StatusOK)
+	doc := goqueryDoc(w.Body)
+	is.Equal(doc.Find(`input[name="next"]`).AttrOr("value", ""), "/admin/jobs")
+	is.True(doc.Find(`input[name="csrf_token"]`).AttrOr("value", "") != "")
+
+	form := url.Values{"username": {"admin"}, "password": {"wrong-password"}, "next": {"/admin/jobs"}}
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, formRequest(s, "POST", "/login", form))
+	is.Equal(w.Code, http.StatusUnauthorized) // Wrong password
+	doc = goqueryDoc(w.Body)
+	is.True(doc.Find(".app-form-error").Text() != "")
+
+	form.Set("password", "correct-horse")
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, formRequest(s, "POST", "/login", form))
+	is.Equal(w.Code, http.StatusSeeOther)
+	is.Equal(w.Header().Get("Location"), "/admin/jobs")
+
+	// The session cookie signs the user in.
+	r := httptest.NewRequest("GET", "/admin/jobs", nil)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == SessionCookie {
+			is.True(cookie.HttpOnly)
+			r.AddCookie(cookie)
+		}
+		if cookie.Name == CSRFCookie {
+			is.True(cookie.MaxAge < 0) // The CSRF cookie of the login form is cleared
+		}
+	}
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusOK)
+	token := goqueryDoc(w.Body).Find(`input[name="csrf_token"]`).AttrOr("value", "")
+	session, ok := s.Sessions.Session(r)
+	is.True(ok)
+	is.Equal(token, s.Sessions.sessionCSRFToken(session)) // The CSRF token is of the session
+
+	// Other sites are not redirected to.
+	form.Set("next", "https://example.com/admin")
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, formRequest(s, "POST", "/login", form))
+	is.Equal(w.Header().Get("Location"), "/admin")
+}
+
+func TestLogoutHandler(t *testing.T) {
+	is := is.New(t)
+	s := newTestAdminServer(t)
+
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, adminRequest(s, "POST", "/logout", url.Values{}))
+	is.Equal(w.Code, http.StatusSeeOther)
+	is.Equal(w.Header().Get("Location"), "/login")
+
+	cleared := 0
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			cleared++
+		}
+	}
+	is.Equal(cleared, 2) // The session and CSRF cookies are cleared
+
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, adminRequest(s, "GET", "/admin/jobs", nil))
+	is.Equal(w.Code, http.StatusSeeOther) // The session cookie is revoked
+
+	r := httptest.NewRequest("GET", "/admin/jobs", nil)
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: s.Sessions.sessionValue("admin", 0, time.Now().Add(time.Hour), "another-session")})
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusOK) // The other sessions of the user continue
+}
+
+func TestLogoutEverywhereHandler(t *testing.T) {
+	is := is.New(t)
+	s := newTestAdminServer(t)
+
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, adminRequest(s, "POST", "/logout/everywhere", url.Values{}))
+	is.Equal(w.Code, http.StatusSeeOther)
+	is.Equal(w.Header().Get("Location"), "/login")
+
+	r := httptest.NewRequest("GET", "/admin/jobs", nil)
+	r.AddCookie(&http.Cookie{Name: SessionCookie, Value: s.Sessions.sessionValue("admin", 0, time.Now().Add(time.Hour), "another-session")})
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, r)
+	is.Equal(w.Code, http.StatusSeeOther) // Every session of the user is revoked
+}
+
+func TestLoginHandler_Limited(t *testing.T) {
+	is := is.New(t)
+	s := newTestAdminServer(t)
+
+	login := func(username, password string) int {
+		form := url.Values{"username": {username}, "password": {password}}
+		w := httptest.NewRecorder()
+		s.Router.ServeHTTP(w, formRequest(s, "POST", "/login", form))
+		return w.Code
+	}
+
+	s.Logins.userDelay = time.Millisecond
+
+	for i := 0; i < LoginMaxUserFailures+1; i++ {
+		is.Equal(login("admin", "wrong-password"), http.StatusUnauthorized)
+	}
+	is.True(s.Logins.Delay("admin") > 0)
+	is.Equal(login("admin", "correct-horse"), http.StatusSeeOther) // The username is delayed, not locked out
+
+	for i := 0; i < LoginMaxClientFailures; i++ {
+		login(fmt.Sprintf("user-%v", i), "wrong-password")
+	}
+	is.Equal(login("admin", "correct-horse"), http.StatusTooManyRequests) // The client is locked out
+}
+
+func TestClientIP(t *testing.T) {
+	is := is.New(t)
+
+	r := httptest.NewRequest("GET", "/login", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Add("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	r.Header.Add("X-Forwarded-For", "192.0.2.1")
+
+	is.Equal(clientIP(r, ""), "10.0.0.1")                 // The header is ignored, unless it is configured
+	is.Equal(clientIP(r, "X-Forwarded-For"), "192.0.2.1") // The address appended by the proxy
+	is.Equal(clientIP(r, "X-Real-IP"), "10.0.0.1")        // The remote address, without the header
+
+	r.Header.Set("X-Real-IP", "unknown")
+	is.Equal(clientIP(r, "X-Real-IP"), "10.0.0.1") // Invalid addresses are ignored
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// CursorFormat is the format of the 'before' pagination cursor.
+const CursorFormat = "2006-01-02 15:04:05"
+
+// apiErrorResponse is the api response for an error.
+type apiErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) apiArticlesHandler(w http.ResponseWriter, r *http.Request) {
+	// Get query params and normalize.
+	query, err := articleQueryParams(r)
+	if err == nil {
+		err = validateCursor(query)
+	}
+	if err == nil {
+		query.Topic, err = apiTopicParam(r)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// Fetch articles.
+	page, err := s.DB.GetArticles(r.Context(), query)
+	if err != nil {
+		apiServerError(w, r, err)
+		return
+	}
+	if !page.HasMore {
+		page.NextCursor = ""
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) apiRecentArticlesHandler(w http.ResponseWriter, r *http.Request) {
+	topic, err := apiTopicParam(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, apiErrorResponse{Error: err.Error()})
+		return
+	}
+
+	// Fetch articles.
+	articles, err := s.DB.GetRecentArticles(r.Context(), topic)
+	if err != nil {
+		apiServerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ArticlePage{Articles: articles})
+}
+
+// apiTopicParam reads the 'topic' query param. The articles of all
+// the topics are returned when it is not set.
+func apiTopicParam(r *http.Request) (string, error) {
+	slug := r.URL.Query().Get("topic")
+	if slug == "" {
+		return "", nil
+	}
+	if _, ok := GetTopic(slug); !ok {
+		return "", fmt.Errorf("unknown topic %q", slug)
+	}
+	return slug, nil
+}
+
+// apiServerError writes the error response for an error which is not the client's fault.
+// The error is logged, and is not exposed in the response.
+func apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	logRequestError(r, err)
+	writeJSON(w, http.StatusInternalServerError, apiErrorResponse{Error: http.StatusText(http.StatusInternalServerError)})
+}
+
+// validateCursor checks the format of the 'before' cursor. A relevance cursor
+// is only valid for a search, otherwise the results are ordered by date.
+func validateCursor(query ArticleQuery) error {
+	if query.Before == "" || query.sortOrder() == SortRelevance {
+		return nil
+	}
+	if _, err := time.Parse(CursorFormat, query.Before); err != nil {
+		return errors.New("invalid 'before' cursor, use the 'next_cursor' of the previous page")
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestAPIArticlesHandler(t *testing.T) {
+	is := is.New(t)
+
+	pubDate := time.Date(2020, 6, 25, 23, 44, 16, 0, time.UTC)
+	var gotQuery, gotCursor string
+
+	mockDB := &MockServerDB{
+		getArticlesMock: func(ctx context.Context, query ArticleQuery) (*ArticlePage, error) {
+			gotQuery, gotCursor = query.Text, query.Before
+			articles := []*Article{
+				{ID: 1, Title: "Article 1", PublishedAt: pubDate.Add(time.Hour)},
+				{ID: 2, Title: "Article 2", LedeImg: "https://example.com/2.png", PublishedAt: pubDate},
+			}
+			return &ArticlePage{Articles: articles, NextCursor: nextCursor(articles, SortDate), HasMore: true}, nil
+		},
+	}
+
+	s := newTestServer(mockDB)
+	r := httptest.NewRequest("GET", "/api/v1/articles", nil)
+	w := httptest.NewRecorder()
+
+	q := url.Values{}
+	q.Add("q", "citizenship")
+	q.Add("sort", "date")
+	q.Add("before", "2020-06-30 00:00:00")
+	r.URL.RawQuery = q.Encode()
+
+	http.HandlerFunc(s.apiArticlesHandler).ServeHTTP(w, r)
+
+	is.Equal(w.Code, http.StatusOK) // Status code
+	is.Equal(gotQuery, "citizenship")
+	is.Equal(gotCursor, "2020-06-30 00:00:00")
+
+	body := map[string]interface{}{}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&body))
+	is.Equal(body["has_more"], true)
+	is.Equal(body["next_cursor"], "2020-06-25 23:44:16") // Cursor of the last article
+
+	article := body["articles"].([]interface{})[1].(map[string]interface{})
+	is.Equal(article["id"], 2.0)
+	is.Equal(article["image"], "https://example.com/2.png")
+	is.Equal(article["published_at"], "2020-06-25T23:44:16Z")
+	_, hasCreatedAt := article["created_at"]
+	is.True(!hasCreatedAt) // Internal fields are not exposed
+}
+
+func TestAPIArticlesHandler_InvalidCursor(t *testing.T) {
+	is := is.New(t)
+
+	s := newTestServer(&MockServerDB{})
+	r := httptest.NewRequest("GET", "/api/v1/articles?before=yesterday", nil)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.apiArticlesHandler).ServeHTTP(w, r)
+
+	is.Equal(w.Code, http.StatusBadRequest) // Status code
+}
+
+func TestAPIArticlesHandler_SearchCursor(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+	insertSearchArticles(db)
+	s := newTestServer(db)
+
+	get := func(before string) (int, *ArticlePage) {
+		q := url.Values{"q": {"citizenship"}, "before": {before}}
+		r := httptest.NewRequest("GET", "/api/v1/articles?"+q.Encode(), nil)
+		w := httptest.NewRecorder()
+		http.HandlerFunc(s.apiArticlesHandler).ServeHTTP(w, r)
+
+		page := &ArticlePage{}
+		json.NewDecoder(w.Body).Decode(page)
+		return w.Code, page
+	}
+
+	// A search is ordered by relevance, and a date cursor continues in date order.
+	code, page := get("2020-06-04 00:00:00")
+	is.Equal(code, http.StatusOK)
+	is.Equal(page.Sort, SortDate)
+	is.Equal(articleTitles(page.Articles), []string{"Citizenship bill introduced"})
+
+	code, _ = get("yesterday")
+	is.Equal(code, http.StatusBadRequest) // Neither a relevance nor a date cursor
+}
+
+func TestAPIRecentArticlesHandler(t *testing.T) {
+	is := is.New(t)
+
+	mockDB := &MockServerDB{
+		getRecentArticlesMock: func(ctx context.Context, topic string) ([]*Article, error) {
+			return []*Article{{ID: 1}, {ID: 2}}, nil
+		},
+	}
+
+	s := newTestServer(mockDB)
+	r := httptest.NewRequest("GET", "/api/v1/articles/recent", nil)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.apiRecentArticlesHandler).ServeHTTP(w, r)
+
+	is.Equal(w.Code, http.StatusOK) // Status code
+
+	body := ArticlePage{}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&body))
+	is.Equal(len(body.Articles), 2)
+	is.Equal(body.HasMore, false)
+}
+
+func TestAPIArticlesHandler_DatabaseError(t *testing.T) {
+	is := is.New(t)
+
+	mockDB := &MockServerDB{
+		getArticlesMock: func(ctx context.Context, query ArticleQuery) (*ArticlePage, error) {
+			return nil, errors.New("disk I/O error")
+		},
+	}
+
+	s := newTestServer(mockDB)
+	r := httptest.NewRequest("GET", "/api/v1/articles", nil)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.apiArticlesHandler).ServeHTTP(w, r)
+
+	is.Equal(w.Code, http.StatusInternalServerError) // Status code
+
+	body := apiErrorResponse{}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&body))
+	is.Equal(body.Error, "Internal Server Error") // The database error is not exposed
+}
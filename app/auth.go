@@ -0,0 +1,424 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUserNotFound is returned when there is no admin user with a username.
+var ErrUserNotFound = errors.New("user not found")
+
+// UserMinPasswordLength is the minimum length of the password of an admin user.
+const UserMinPasswordLength = 10
+
+// usernamePattern matches the valid usernames.
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{3,50}$`)
+
+// User is an admin user, who can sign in to the admin pages.
+type User struct {
+	ID           int       `db:"id"`
+	Username     string    `db:"username"`
+	PasswordHash string    `db:"password_hash"`
+	CreatedAt    time.Time `db:"created_at"`
+
+	// SessionVersion is the version of the sessions of the user. The sessions
+	// of an older version are revoked, see Database.RevokeSessions.
+	SessionVersion int `db:"session_version"`
+}
+
+// NewUser creates an admin user, with the password hashed by bcrypt.
+func NewUser(username, password string) (*User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("invalid username %q, use 3 to 50 letters, numbers, dots, dashes and underscores", username)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return &User{Username: username, PasswordHash: hash, CreatedAt: time.Now().UTC()}, nil
+}
+
+// hashPassword validates the password, and hashes it with bcrypt.
+func hashPassword(password string) (string, error) {
+	if len(password) < UserMinPasswordLength {
+		return "", fmt.Errorf("the password must have at least %v characters", UserMinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("could not hash the password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether the password is the password of the user.
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// CreateUser validates and saves a new admin user.
+func CreateUser(ctx context.Context, db Database, username, password string) (*User, error) {
+	if _, err := db.GetUser(ctx, username); err == nil {
+		return nil, fmt.Errorf("user %q already exists", username)
+	} else if !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+
+	user, err := NewUser(username, password)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.InsertUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ChangePassword validates and saves the new password of the admin user.
+// The sessions of the user are revoked, so a stolen session cookie stops working.
+func ChangePassword(ctx context.Context, db Database, username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return db.UpdateUserPassword(ctx, username, hash)
+}
+
+// dummyPasswordHash is compared when a user does not exist, so that signing
+// in as an unknown user takes as long as signing in with a wrong password.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dacabot-dummy-password"), bcrypt.DefaultCost)
+
+// Authenticate returns the user with the username and password, or ErrUserNotFound
+// when the username or the password is wrong.
+func Authenticate(ctx context.Context, db Database, username, password string) (*User, error) {
+	user, err := db.GetUser(ctx, username)
+	if errors.Is(err, ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.CheckPassword(password) {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// ------------------------------------------------------------------
+// Sessions
+// ------------------------------------------------------------------
+
+// Cookies of the admin sessions.
+const (
+	SessionCookie = "dacabot_session"
+	CSRFCookie    = "dacabot_csrf"
+)
+
+// SessionDuration is how long an admin stays signed in.
+const SessionDuration = 7 * 24 * time.Hour
+
+// SessionSecretMinLength is the minimum length of a configured session secret.
+const SessionSecretMinLength = 32
+
+// SessionStore signs the session and CSRF cookies of the admin pages.
+// A cookie is its value and its HMAC-SHA256 signature, so it cannot be
+// forged or changed without the secret.
+type SessionStore struct {
+	secret []byte
+}
+
+// NewSessionStore creates a SessionStore which signs the cookies with the secret.
+// A random secret is used when it is empty, and the sessions end when the server restarts.
+func NewSessionStore(secret string) *SessionStore {
+	if secret == "" {
+		secret = randomToken()
+	}
+	return &SessionStore{secret: []byte(secret)}
+}
+
+// randomToken is a random hex string of 32 bytes.
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("could not generate a random token: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// mac is the HMAC-SHA256 signature of the value.
+func (s *SessionStore) mac(value string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sign appends the signature to the value.
+func (s *SessionStore) sign(value string) string {
+	return value + "." + s.mac(value)
+}
+
+// verify returns the value of a signed value, if its signature is valid.
+func (s *SessionStore) verify(signed string) (string, bool) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", false
+	}
+	value := signed[:i]
+	if !hmac.Equal([]byte(s.sign(value)), []byte(signed)) {
+		return "", false
+	}
+	return value, true
+}
+
+// cookie creates a cookie of the admin pages, which is not readable by scripts.
+func (s *SessionStore) cookie(r *http.Request, name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// sessionValue is the signed value of the session cookie of the user, with the
+// session version of the user and the id of the session, ex: "YWxpY2U|0|1597017600|3f0c...".
+func (s *SessionStore) sessionValue(username string, version int, expires time.Time, id string) string {
+	value := base64.RawURLEncoding.EncodeToString([]byte(username)) + "|" +
+		strconv.Itoa(version) + "|" + strconv.FormatInt(expires.Unix(), 10) + "|" + id
+	return s.sign(value)
+}
+
+// Login starts a new session of the user. The CSRF cookie of the login form is cleared,
+// as the CSRF token of the session is derived from the session cookie.
+func (s *SessionStore) Login(w http.ResponseWriter, r *http.Request, user *User) {
+	expires := time.Now().Add(SessionDuration)
+	value := s.sessionValue(user.Username, user.SessionVersion, expires, randomToken())
+	http.SetCookie(w, s.cookie(r, SessionCookie, value, int(SessionDuration.Seconds())))
+	http.SetCookie(w, s.cookie(r, CSRFCookie, "", -1))
+}
+
+// Logout ends the session.
+func (s *SessionStore) Logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, s.cookie(r, SessionCookie, "", -1))
+	http.SetCookie(w, s.cookie(r, CSRFCookie, "", -1))
+}
+
+// Session is the session of a signed in admin user.
+type Session struct {
+	ID        string
+	Username  string
+	Version   int
+	ExpiresAt time.Time
+
+	// cookie is the signed value of the session cookie.
+	cookie string
+}
+
+// Session returns the session of the request, if its cookie is valid. The session
+// is revoked when its version is not the session version of the user, or when its
+// id was revoked, see Database.RevokeSession.
+func (s *SessionStore) Session(r *http.Request) (*Session, bool) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return nil, false
+	}
+	value, ok := s.verify(cookie.Value)
+	if !ok {
+		return nil, false
+	}
+
+	parts := strings.Split(value, "|")
+	if len(parts) != 4 || parts[3] == "" {
+		return nil, false
+	}
+	username, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, false
+	}
+	version, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, false
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, false
+	}
+	return &Session{
+		ID:        parts[3],
+		Username:  string(username),
+		Version:   version,
+		ExpiresAt: time.Unix(expires, 0).UTC(),
+		cookie:    cookie.Value,
+	}, true
+}
+
+// sessionCSRFToken is the CSRF token of the session, which is valid only in that session.
+func (s *SessionStore) sessionCSRFToken(session *Session) string {
+	return s.mac("csrf|" + session.cookie)
+}
+
+// CSRFToken returns the CSRF token of the request, which the forms submit in the
+// 'csrf_token' field. In a session, the token is derived from the session cookie.
+// Otherwise, a new token is set in a cookie when the request has none.
+func (s *SessionStore) CSRFToken(w http.ResponseWriter, r *http.Request) string {
+	if session, ok := s.Session(r); ok {
+		return s.sessionCSRFToken(session)
+	}
+	if cookie, err := r.Cookie(CSRFCookie); err == nil {
+		if _, ok := s.verify(cookie.Value); ok {
+			return cookie.Value
+		}
+	}
+
+	token := s.sign(randomToken())
+	http.SetCookie(w, s.cookie(r, CSRFCookie, token, 0))
+	return token
+}
+
+// CheckCSRF reports whether the 'csrf_token' field of the form matches the CSRF token
+// of the session, or the CSRF cookie when the request has no session.
+func (s *SessionStore) CheckCSRF(r *http.Request) bool {
+	token := r.PostFormValue("csrf_token")
+	if session, ok := s.Session(r); ok {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(s.sessionCSRFToken(session))) == 1
+	}
+
+	cookie, err := r.Cookie(CSRFCookie)
+	if err != nil {
+		return false
+	}
+	if _, ok := s.verify(cookie.Value); !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) == 1
+}
+
+// ------------------------------------------------------------------
+// Login attempts
+// ------------------------------------------------------------------
+
+// Limits of the failed sign-ins, within a window. When a client has failed to sign in
+// too many times, its sign-ins are refused until the window ends. When a username has,
+// its sign-ins are delayed, and the delay doubles with each failure, up to the maximum.
+// A username is not locked out, so that its admin can still sign in while it is guessed.
+const (
+	LoginMaxUserFailures   = 5
+	LoginMaxClientFailures = 20
+	LoginFailureWindow     = 15 * time.Minute
+	LoginUserDelay         = time.Second
+	LoginMaxUserDelay      = 30 * time.Second
+)
+
+// loginFailures counts the failed sign-ins of a username or a client, in a window.
+type loginFailures struct {
+	count   int
+	resetAt time.Time
+}
+
+// LoginLimiter limits the failed sign-ins of each username and of each client,
+// so that the passwords cannot be guessed. It is safe for concurrent use.
+type LoginLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+
+	// userDelay is the first delay of the sign-ins of a username, see LoginUserDelay.
+	userDelay time.Duration
+}
+
+// NewLoginLimiter creates a LoginLimiter without failed sign-ins.
+func NewLoginLimiter() *LoginLimiter {
+	return &LoginLimiter{failures: map[string]*loginFailures{}, userDelay: LoginUserDelay}
+}
+
+// loginKeys are the keys of the failures of the username and of the client.
+func loginKeys(username, client string) (string, string) {
+	return "user:" + strings.ToLower(username), "client:" + client
+}
+
+// Allowed reports whether the client can sign in.
+func (l *LoginLimiter) Allowed(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, clientKey := loginKeys("", client)
+	return l.count(clientKey, time.Now()) < LoginMaxClientFailures
+}
+
+// Delay is how long a sign-in of the username is delayed, after its failed sign-ins.
+func (l *LoginLimiter) Delay(username string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	userKey, _ := loginKeys(username, "")
+	failures := l.count(userKey, time.Now()) - LoginMaxUserFailures
+	if failures < 0 {
+		return 0
+	}
+	delay := l.userDelay
+	for i := 0; i < failures && delay < LoginMaxUserDelay; i++ {
+		delay *= 2
+	}
+	if delay > LoginMaxUserDelay {
+		delay = LoginMaxUserDelay
+	}
+	return delay
+}
+
+// Failed records a failed sign-in of the username from the client.
+func (l *LoginLimiter) Failed(username, client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, failures := range l.failures {
+		if !now.Before(failures.resetAt) {
+			delete(l.failures, key)
+		}
+	}
+
+	userKey, clientKey := loginKeys(username, client)
+	for _, key := range []string{userKey, clientKey} {
+		failures, ok := l.failures[key]
+		if !ok {
+			failures = &loginFailures{resetAt: now.Add(LoginFailureWindow)}
+			l.failures[key] = failures
+		}
+		failures.count++
+	}
+}
+
+// Succeeded clears the failed sign-ins of the username.
+func (l *LoginLimiter) Succeeded(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	userKey, _ := loginKeys(username, "")
+	delete(l.failures, userKey)
+}
+
+// count returns the failures of the key in the current window.
+func (l *LoginLimiter) count(key string, now time.Time) int {
+	failures, ok := l.failures[key]
+	if !ok || !now.Before(failures.resetAt) {
+		return 0
+	}
+	return failures.count
+}
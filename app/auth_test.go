@@ -0,0 +1,189 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestNewUser(t *testing.T) {
+	is := is.New(t)
+
+	user, err := NewUser("alice", "correct-horse")
+	is.NoErr(err)
+	is.True(user.PasswordHash != "correct-horse") // The password is hashed
+	is.True(user.CheckPassword("correct-horse"))
+	is.True(!user.CheckPassword("wrong-password"))
+
+	_, err = NewUser("al", "correct-horse")
+	is.True(err != nil) // Username too short
+	_, err = NewUser("alice smith", "correct-horse")
+	is.True(err != nil) // Invalid username
+	_, err = NewUser("alice", "short")
+	is.True(err != nil) // Password too short
+}
+
+func TestAuthenticate(t *testing.T) {
+	is := is.New(t)
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+	ctx := context.Background()
+
+	_, err := CreateUser(ctx, db, "alice", "correct-horse")
+	is.NoErr(err)
+	_, err = CreateUser(ctx, db, "alice", "another-password")
+	is.True(err != nil) // The user exists
+
+	user, err := Authenticate(ctx, db, "alice", "correct-horse")
+	is.NoErr(err)
+	is.Equal(user.Username, "alice")
+
+	_, err = Authenticate(ctx, db, "alice", "wrong-password")
+	is.True(errors.Is(err, ErrUserNotFound))
+	_, err = Authenticate(ctx, db, "bob", "correct-horse")
+	is.True(errors.Is(err, ErrUserNotFound))
+}
+
+func TestChangePassword(t *testing.T) {
+	is := is.New(t)
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+	ctx := context.Background()
+
+	_, err := CreateUser(ctx, db, "alice", "correct-horse")
+	is.NoErr(err)
+
+	is.True(ChangePassword(ctx, db, "alice", "short") != nil) // Password too short
+	is.NoErr(ChangePassword(ctx, db, "alice", "battery-staple"))
+	is.True(errors.Is(ChangePassword(ctx, db, "bob", "battery-staple"), ErrUserNotFound))
+
+	user, err := Authenticate(ctx, db, "alice", "battery-staple")
+	is.NoErr(err)
+	is.Equal(user.SessionVersion, 1) // The sessions are revoked
+	_, err = Authenticate(ctx, db, "alice", "correct-horse")
+	is.True(errors.Is(err, ErrUserNotFound))
+}
+
+func TestSessionStore_Session(t *testing.T) {
+	is := is.New(t)
+	sessions := NewSessionStore("test-session-secret-of-32-characters")
+
+	request := func(value string) *http.Request {
+		r := httptest.NewRequest("GET", "/admin", nil)
+		r.AddCookie(&http.Cookie{Name: SessionCookie, Value: value})
+		return r
+	}
+
+	valid := sessions.sessionValue("alice", 2, time.Now().Add(time.Hour), "session-1")
+	session, ok := sessions.Session(request(valid))
+	is.True(ok)
+	is.Equal(session.Username, "alice")
+	is.Equal(session.Version, 2)
+	is.Equal(session.ID, "session-1")
+	is.True(session.ExpiresAt.After(time.Now()))
+
+	_, ok = sessions.Session(request(sessions.sessionValue("alice", 2, time.Now().Add(time.Hour), "")))
+	is.True(!ok) // A session without an id
+
+	forged := sessions.sessionValue("bob", 2, time.Now().Add(time.Hour), "session-1")
+	i := strings.LastIndex(forged, ".")
+	_, ok = sessions.Session(request(forged[:i] + valid[strings.LastIndex(valid, "."):]))
+	is.True(!ok) // The signature does not match
+
+	_, ok = sessions.Session(request(NewSessionStore("another-secret").sessionValue("alice", 2, time.Now().Add(time.Hour), "session-1")))
+	is.True(!ok) // Signed with another secret
+
+	_, ok = sessions.Session(request(sessions.sessionValue("alice", 2, time.Now().Add(-time.Minute), "session-1")))
+	is.True(!ok) // Expired
+
+	_, ok = sessions.Session(httptest.NewRequest("GET", "/admin", nil))
+	is.True(!ok) // No cookie
+}
+
+func TestSessionStore_CheckCSRF(t *testing.T) {
+	is := is.New(t)
+	sessions := NewSessionStore("test-session-secret-of-32-characters")
+
+	w := httptest.NewRecorder()
+	token := sessions.CSRFToken(w, httptest.NewRequest("GET", "/login", nil))
+	cookies := w.Result().Cookies()
+	is.Equal(len(cookies), 1) // A new token is set
+	is.Equal(cookies[0].Value, token)
+
+	request := func(cookie, field string) *http.Request {
+		r := httptest.NewRequest("POST", "/login", strings.NewReader(url.Values{"csrf_token": {field}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != "" {
+			r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: cookie})
+		}
+		return r
+	}
+
+	is.True(sessions.CheckCSRF(request(token, token)))
+	is.True(!sessions.CheckCSRF(request(token, "")))                           // No token
+	is.True(!sessions.CheckCSRF(request("", token)))                           // No cookie
+	is.True(!sessions.CheckCSRF(request(token, sessions.sign(randomToken())))) // Another token
+	is.True(!sessions.CheckCSRF(request("forged.token", "forged.token")))      // Not signed
+
+	w = httptest.NewRecorder()
+	is.Equal(sessions.CSRFToken(w, request(token, token)), token) // The token is kept
+	is.Equal(len(w.Result().Cookies()), 0)
+
+	// In a session, the token is bound to the session cookie.
+	inSession := func(value, field string) *http.Request {
+		r := request(token, field)
+		r.AddCookie(&http.Cookie{Name: SessionCookie, Value: value})
+		return r
+	}
+	alice := sessions.sessionValue("alice", 0, time.Now().Add(time.Hour), "session-1")
+	bob := sessions.sessionValue("bob", 0, time.Now().Add(time.Hour), "session-2")
+	w = httptest.NewRecorder()
+	aliceToken := sessions.CSRFToken(w, inSession(alice, ""))
+	is.Equal(len(w.Result().Cookies()), 0)
+	is.True(aliceToken != token)
+
+	is.True(sessions.CheckCSRF(inSession(alice, aliceToken)))
+	is.True(!sessions.CheckCSRF(inSession(alice, token)))    // The token of the cookie
+	is.True(!sessions.CheckCSRF(inSession(bob, aliceToken))) // The token of another session
+}
+
+func TestLoginLimiter(t *testing.T) {
+	is := is.New(t)
+	logins := NewLoginLimiter()
+
+	for i := 0; i < LoginMaxUserFailures; i++ {
+		is.Equal(logins.Delay("alice"), time.Duration(0))
+		logins.Failed("alice", fmt.Sprintf("10.0.0.%v", i))
+	}
+	is.Equal(logins.Delay("Alice"), LoginUserDelay) // The username is delayed from every client
+	logins.Failed("alice", "10.0.0.1")
+	is.Equal(logins.Delay("alice"), 2*LoginUserDelay) // The delay doubles with each failure
+	for i := 0; i < 10; i++ {
+		logins.Failed("alice", "10.0.0.2")
+	}
+	is.Equal(logins.Delay("alice"), LoginMaxUserDelay)
+	is.Equal(logins.Delay("bob"), time.Duration(0))
+
+	logins.failures["user:alice"].resetAt = time.Now().Add(-time.Second)
+	is.Equal(logins.Delay("alice"), time.Duration(0)) // The window ended
+
+	for i := 0; i < LoginMaxClientFailures; i++ {
+		is.True(logins.Allowed("10.0.1.1"))
+		logins.Failed(fmt.Sprintf("user-%v", i), "10.0.1.1")
+	}
+	is.True(!logins.Allowed("10.0.1.1")) // The client is refused
+	is.True(logins.Allowed("10.0.1.2"))
+
+	logins.Failed("bob", "10.0.0.1")
+	logins.Succeeded("bob")
+	is.Equal(logins.count("user:bob", time.Now()), 0) // Cleared by a sign-in
+	is.Equal(logins.count("client:10.0.0.1", time.Now()), 3)
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Backfill window sizes, in days.
+const (
+	BackfillDay  = 1
+	BackfillWeek = 7
+)
+
+// BackfillWindow is a date range of a backfill, and the outcome of fetching it.
+// The dates are inclusive.
+type BackfillWindow struct {
+	ID          int       `db:"id"`
+	Topic       string    `db:"topic"`
+	From        time.Time `db:"window_from"`
+	To          time.Time `db:"window_to"`
+	Status      string    `db:"status"`
+	Fetched     int       `db:"fetched"`
+	Inserted    int       `db:"inserted"`
+	Updated     int       `db:"updated"`
+	Failed      int       `db:"failed"`
+	Error       string    `db:"error"`
+	CompletedAt time.Time `db:"completed_at"`
+
+	// Skipped is set when the window was completed by a previous backfill.
+	Skipped bool `db:"-"`
+}
+
+func (w *BackfillWindow) String() string {
+	return fmt.Sprintf("%v to %v", w.From.Format("2006-01-02"), w.To.Format("2006-01-02"))
+}
+
+// coveredBy reports whether the window is within one of the windows.
+func (w *BackfillWindow) coveredBy(windows []*BackfillWindow) bool {
+	for _, other := range windows {
+		if !other.From.After(w.From) && !other.To.Before(w.To) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitWindows splits the date range into windows of the given number of days.
+// The last window ends at the end of the range.
+func SplitWindows(topic string, from, to time.Time, days int) []*BackfillWindow {
+	if days < 1 {
+		days = 1
+	}
+	from, to = truncateDay(from), truncateDay(to)
+
+	windows := []*BackfillWindow{}
+	for start := from; !start.After(to); start = start.AddDate(0, 0, days) {
+		end := start.AddDate(0, 0, days-1)
+		if end.After(to) {
+			end = to
+		}
+		windows = append(windows, &BackfillWindow{Topic: topic, From: start, To: end})
+	}
+	return windows
+}
+
+// truncateDay returns the start of the day, in UTC.
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Backfill fetches the articles of the topic in a date range from the sources, one
+// window at a time, so that each request stays under the result cap of the sources.
+// Every window is recorded, and windows which were completed by a previous backfill
+// are skipped, so an interrupted backfill resumes where it stopped. Windows which
+// failed, or partially failed, are fetched again.
+//
+// Each window holds the lease of the update task while it is fetched, so that a backfill
+// does not overlap the scheduled updates. A window which finds the task locked is
+// recorded as skipped, and is fetched again by the next backfill.
+//
+// When the context is done, the windows processed so far are returned with the
+// context's error. The window in progress is not recorded.
+func Backfill(ctx context.Context, db Database, sources []Source, topic *Topic, from, to time.Time, days int) ([]*BackfillWindow, error) {
+	completed, err := db.GetCompletedBackfillWindows(ctx, topic.Slug)
+	if err != nil {
+		return nil, err
+	}
+
+	windows := SplitWindows(topic.Slug, from, to, days)
+	processed := []*BackfillWindow{}
+
+	for i, window := range windows {
+		if window.coveredBy(completed) {
+			window.Skipped = true
+			window.Status = TaskStatusSuccess
+			processed = append(processed, window)
+			fmt.Printf("[backfill] %v/%v %v: already completed\n", i+1, len(windows), window)
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return processed, ctx.Err()
+		}
+
+		fmt.Printf("[backfill] %v/%v %v\n", i+1, len(windows), window)
+		lease, err := AcquireTaskLease(ctx, db, TaskUpdateArticles, TaskLeaseTTL)
+		if errors.Is(err, ErrTaskLocked) {
+			fmt.Printf("[backfill] %v/%v %v: skipped: %v\n", i+1, len(windows), window, err)
+			window.Status = TaskStatusSkipped
+			window.Error = err.Error()
+			window.CompletedAt = time.Now().UTC()
+			if _, err := db.InsertBackfillWindow(ctx, window); err != nil {
+				return processed, err
+			}
+			processed = append(processed, window)
+			continue
+		}
+		if err != nil {
+			return processed, err
+		}
+
+		result := fetchArticles(ctx, db, sources, topic, window.From, window.To)
+		ReleaseTaskLease(db, lease)
+		if ctx.Err() != nil {
+			return processed, ctx.Err()
+		}
+
+		tasklog := result.TaskLog(true)
+		window.Status = tasklog.Status
+		window.Fetched = tasklog.Fetched
+		window.Inserted = tasklog.Inserted
+		window.Updated = tasklog.Updated
+		window.Failed = tasklog.Failed
+		window.Error = tasklog.Error
+		window.CompletedAt = result.FinishedAt
+
+		if _, err := db.InsertBackfillWindow(ctx, window); err != nil {
+			return processed, err
+		}
+		processed = append(processed, window)
+	}
+
+	return processed, nil
+}
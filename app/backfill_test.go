@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+// Test Helpers
+
+// windowSource implements the Source interface. It returns an article for each
+// requested window, and fails the windows which start on a failing date.
+type windowSource struct {
+	requests []time.Time
+	failing  map[string]bool
+}
+
+func (s *windowSource) Name() string {
+	return "window"
+}
+
+func (s *windowSource) GetArticles(ctx context.Context, q string, from, to time.Time) ([]*Article, error) {
+	s.requests = append(s.requests, from)
+	if s.failing[from.Format("2006-01-02")] {
+		return nil, errors.New("boom")
+	}
+	return []*Article{{
+		URL:         "https://example.com/" + from.Format("2006-01-02"),
+		Title:       "Article " + from.Format("2006-01-02"),
+		Source:      "window",
+		PublishedAt: from,
+		CreatedAt:   from,
+	}}, nil
+}
+
+// ------------------------------------------------------------------
+
+func TestSplitWindows(t *testing.T) {
+	is := is.New(t)
+
+	from := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 6, 17, 0, 0, 0, 0, time.UTC)
+
+	windows := SplitWindows("daca", from, to, BackfillWeek)
+	is.Equal(len(windows), 3)
+	is.Equal(windows[0].String(), "2020-06-01 to 2020-06-07")
+	is.Equal(windows[1].String(), "2020-06-08 to 2020-06-14")
+	is.Equal(windows[2].String(), "2020-06-15 to 2020-06-17") // The last window ends at the end of the range
+
+	windows = SplitWindows("daca", from, from, BackfillDay)
+	is.Equal(len(windows), 1)
+	is.Equal(windows[0].String(), "2020-06-01 to 2020-06-01")
+
+	windows = SplitWindows("daca", to, from, BackfillDay)
+	is.Equal(len(windows), 0) // An empty range has no windows
+}
+
+func TestBackfill_Resumes(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+
+	from := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 6, 4, 0, 0, 0, 0, time.UTC)
+	source := &windowSource{failing: map[string]bool{"2020-06-03": true}}
+
+	ctx := context.Background()
+	windows, err := Backfill(ctx, db, []Source{source}, DefaultTopic, from, to, BackfillDay)
+	is.NoErr(err)
+	is.Equal(len(windows), 4)
+	is.Equal(len(source.requests), 4)
+	is.Equal(windows[0].Inserted, 1)
+	is.Equal(windows[2].Status, TaskStatusFailed)
+	is.Equal(windows[2].Error, "window: boom")
+
+	// Only the failed window is fetched again.
+	source.requests = nil
+	source.failing = nil
+	windows, err = Backfill(ctx, db, []Source{source}, DefaultTopic, from, to, BackfillDay)
+	is.NoErr(err)
+	is.Equal(len(windows), 4)
+	is.Equal(source.requests, []time.Time{from.AddDate(0, 0, 2)})
+	is.True(windows[0].Skipped)
+	is.Equal(windows[2].Status, TaskStatusSuccess)
+
+	// Smaller windows within a completed window are skipped.
+	source.requests = nil
+	_, err = Backfill(ctx, db, []Source{source}, DefaultTopic, from, from.AddDate(0, 0, 1), BackfillDay)
+	is.NoErr(err)
+	is.Equal(len(source.requests), 0)
+
+	count, err := db.CountArticles(ctx, from)
+	is.NoErr(err)
+	is.Equal(count, 4)
+}
+
+func TestBackfill_Cancelled(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	from := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	source := &windowSource{}
+	windows, err := Backfill(ctx, db, []Source{source}, DefaultTopic, from, from.AddDate(0, 0, 6), BackfillDay)
+	is.True(errors.Is(err, context.Canceled))
+	is.Equal(len(windows), 0)
+	is.Equal(len(source.requests), 0) // No windows are fetched
+}
+
+func TestBackfill_Locked(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+
+	ctx := context.Background()
+	lease, err := AcquireTaskLease(ctx, db, TaskUpdateArticles, time.Minute)
+	is.NoErr(err)
+
+	from := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+	source := &windowSource{}
+	windows, err := Backfill(ctx, db, []Source{source}, DefaultTopic, from, to, BackfillDay)
+	is.NoErr(err)
+	is.Equal(len(windows), 2)
+	is.Equal(len(source.requests), 0) // No windows are fetched while the task is locked
+	is.Equal(windows[0].Status, TaskStatusSkipped)
+	is.True(strings.Contains(windows[0].Error, ErrTaskLocked.Error()))
+	is.True(!windows[0].Skipped)
+
+	// The skipped windows are not completed, and are fetched by the next backfill.
+	ReleaseTaskLease(db, lease)
+	windows, err = Backfill(ctx, db, []Source{source}, DefaultTopic, from, to, BackfillDay)
+	is.NoErr(err)
+	is.Equal(len(source.requests), 2)
+	is.Equal(windows[1].Status, TaskStatusSuccess)
+}
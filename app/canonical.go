@@ -0,0 +1,290 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// trackingParams are query params which identify the referrer, not the article.
+// Params starting with 'utm_' are always removed.
+var trackingParams = map[string]bool{
+	"fbclid":               true,
+	"gclid":                true,
+	"dclid":                true,
+	"mc_cid":               true,
+	"mc_eid":               true,
+	"cmpid":                true,
+	"ocid":                 true,
+	"ref":                  true,
+	"ref_src":              true,
+	"smid":                 true,
+	"taid":                 true,
+	"amp":                  true,
+	"__twitter_impression": true,
+}
+
+// CanonicalURL normalizes the url of an article, so that the variants of an
+// article's url are stored once. The scheme is always https, the 'www.' and
+// 'amp.' hosts are merged, and tracking params, amp paths, fragments and
+// trailing slashes are removed. The remaining query params are sorted.
+// Urls which cannot be parsed are returned trimmed.
+func CanonicalURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	host = strings.TrimPrefix(host, "amp.")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		host += ":" + port
+	}
+
+	path := u.Path
+	path = strings.TrimSuffix(path, "/")
+	path = strings.TrimSuffix(path, "/amp")
+	path = strings.TrimPrefix(path, "/amp/")
+	if strings.HasSuffix(path, ".amp.html") {
+		path = strings.TrimSuffix(path, ".amp.html") + ".html"
+	}
+	path = strings.TrimSuffix(path, ".amp")
+	path = strings.TrimSuffix(path, "/")
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	query := u.Query()
+	for param, values := range query {
+		name := strings.ToLower(param)
+		if strings.HasPrefix(name, "utm_") || trackingParams[name] {
+			query.Del(param)
+		}
+		if name == "outputtype" && len(values) > 0 && values[0] == "amp" {
+			query.Del(param)
+		}
+	}
+
+	canonical := url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     path,
+		RawQuery: query.Encode(),
+	}
+	return canonical.String()
+}
+
+// StorySimilarity is the minimum similarity of the titles of two articles
+// for them to be considered the same story.
+const StorySimilarity = 0.5
+
+// StoryWindow is how far apart two articles of the same story can be published.
+const StoryWindow = 2 * 24 * time.Hour
+
+// findStory returns the story of the article among the candidate articles, such as
+// the story of a syndicated copy of a wire story. An article with the same canonical
+// url is the same story. Otherwise the story of the most similar title is returned,
+// if it is similar enough. Zero is returned when the article is a new story.
+func findStory(article *Article, candidates []*Article) int {
+	for _, candidate := range candidates {
+		if article.CanonicalURL != "" && candidate.CanonicalURL == article.CanonicalURL {
+			return candidate.StoryID
+		}
+	}
+
+	shingles := titleShingles(article.Title)
+	storyID, best := 0, 0.0
+	for _, candidate := range candidates {
+		if similarity := jaccard(shingles, titleShingles(candidate.Title)); similarity >= StorySimilarity && similarity > best {
+			storyID, best = candidate.StoryID, similarity
+		}
+	}
+	return storyID
+}
+
+// assignStory sets the story of a saved article. The article is compared with
+// the articles published within the StoryWindow, and starts a new story when
+// none of them is the same story. Articles which already have a story are skipped.
+func assignStory(ctx context.Context, tx sqlx.ExtContext, id int, timeArg func(time.Time) interface{}) error {
+	article := &Article{}
+	sql := tx.Rebind(`SELECT id, title, canonical_url, published_at, story_id FROM article WHERE id = ?;`)
+	if err := sqlx.GetContext(ctx, tx, article, sql, id); err != nil {
+		return fmt.Errorf("could not assign the story of article %v: %w", id, err)
+	}
+	if article.StoryID != 0 {
+		return nil
+	}
+
+	candidates := []*Article{}
+	sql = tx.Rebind(`
+		SELECT id, title, canonical_url, story_id
+		FROM article
+		WHERE story_id != 0 AND id != ?
+			AND (canonical_url = ? OR (published_at >= ? AND published_at <= ?))
+		ORDER BY published_at DESC, id;`)
+	from, to := article.PublishedAt.Add(-StoryWindow), article.PublishedAt.Add(StoryWindow)
+	if err := sqlx.SelectContext(ctx, tx, &candidates, sql, id, article.CanonicalURL, timeArg(from), timeArg(to)); err != nil {
+		return fmt.Errorf("could not assign the story of article %v: %w", id, err)
+	}
+
+	storyID := findStory(article, candidates)
+	if storyID == 0 {
+		storyID = id
+	}
+	if _, err := tx.ExecContext(ctx, tx.Rebind(`UPDATE article SET story_id = ? WHERE id = ?;`), storyID, id); err != nil {
+		return fmt.Errorf("could not assign the story of article %v: %w", id, err)
+	}
+	return nil
+}
+
+// attachDuplicates sets the Duplicates of the articles to the other articles of their
+// stories which match the filters of the query. The search text is not matched, so
+// every copy of a story is listed under the article which matched.
+func attachDuplicates(ctx context.Context, db *sqlx.DB, query ArticleQuery, articles []*Article, timeArg func(time.Time) interface{}) error {
+	stories := map[int]*Article{}
+	storyIDs, ids := []int{}, []int{}
+	for _, article := range articles {
+		ids = append(ids, article.ID)
+		if article.StoryID != 0 {
+			stories[article.StoryID] = article
+			storyIDs = append(storyIDs, article.StoryID)
+		}
+	}
+	if len(storyIDs) == 0 {
+		return nil
+	}
+
+	filters, args := query.filterSQL("a", timeArg)
+	sql, args, err := sqlx.In(`
+		SELECT a.*
+		FROM article a
+		WHERE a.story_id IN (?) AND a.id NOT IN (?)`+filters+`
+		ORDER BY a.published_at DESC, a.id;`, append([]interface{}{storyIDs, ids}, args...)...)
+	if err != nil {
+		return fmt.Errorf("could not fetch duplicate articles: %w", err)
+	}
+
+	duplicates := []*Article{}
+	if err := db.SelectContext(ctx, &duplicates, db.Rebind(sql), args...); err != nil {
+		return fmt.Errorf("could not fetch duplicate articles: %w", err)
+	}
+	for _, duplicate := range duplicates {
+		primary := stories[duplicate.StoryID]
+		primary.Duplicates = append(primary.Duplicates, duplicate)
+	}
+	return nil
+}
+
+// normalizeTitle lowercases the title, and removes its punctuation and the
+// name of the source, which is often appended like "Title - Source".
+func normalizeTitle(title string) []string {
+	for _, separator := range []string{" | ", " - ", " — "} {
+		if i := strings.LastIndex(title, separator); i > 0 {
+			// Only short suffixes are the name of the source.
+			if suffix := strings.Fields(title[i+len(separator):]); len(suffix) <= 3 {
+				title = title[:i]
+			}
+		}
+	}
+
+	return strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// titleShingles are the pairs of consecutive words of the normalized title.
+// Titles of a single word are their own shingle.
+func titleShingles(title string) []string {
+	words := normalizeTitle(title)
+	if len(words) < 2 {
+		return words
+	}
+
+	shingles := []string{}
+	for i := 0; i < len(words)-1; i++ {
+		shingles = append(shingles, words[i]+" "+words[i+1])
+	}
+	return shingles
+}
+
+// jaccard is the similarity of two sets of shingles, from 0 to 1.
+func jaccard(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	set := map[string]bool{}
+	for _, shingle := range a {
+		set[shingle] = true
+	}
+
+	shared := 0
+	union := len(set)
+	seen := map[string]bool{}
+	for _, shingle := range b {
+		if seen[shingle] {
+			continue
+		}
+		seen[shingle] = true
+		if set[shingle] {
+			shared++
+		} else {
+			union++
+		}
+	}
+	return float64(shared) / float64(union)
+}
+
+// backfillStories assigns the stories of the articles which were saved before
+// stories existed. The articles are assigned in the order they were published, so
+// that the articles with the same canonical url, which were saved before canonical
+// urls existed, are grouped into one story.
+func backfillStories(db *sqlx.DB, timeArg func(time.Time) interface{}) error {
+	ids := []int{}
+	if err := db.Select(&ids, `SELECT id FROM article WHERE story_id = 0 ORDER BY published_at, id;`); err != nil {
+		return fmt.Errorf("could not backfill stories: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	fmt.Printf("[migrate] grouping the stories of %v articles\n", len(ids))
+	return inTx(db, func(tx *sqlx.Tx) error {
+		for _, id := range ids {
+			if err := assignStory(context.Background(), tx, id, timeArg); err != nil {
+				return fmt.Errorf("could not backfill stories: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// backfillCanonicalURLs sets the canonical url of the articles which were
+// saved before canonical urls existed.
+func backfillCanonicalURLs(db *sqlx.DB) error {
+	articles := []*Article{}
+	if err := db.Select(&articles, `SELECT id, url FROM article WHERE canonical_url = '';`); err != nil {
+		return fmt.Errorf("could not backfill canonical urls: %w", err)
+	}
+	if len(articles) == 0 {
+		return nil
+	}
+
+	fmt.Printf("[migrate] setting the canonical url of %v articles\n", len(articles))
+	return inTx(db, func(tx *sqlx.Tx) error {
+		update := tx.Rebind(`UPDATE article SET canonical_url = ? WHERE id = ?;`)
+		for _, article := range articles {
+			if _, err := tx.Exec(update, CanonicalURL(article.URL), article.ID); err != nil {
+				return fmt.Errorf("could not backfill canonical urls: %w", err)
+			}
+		}
+		return nil
+	})
+}
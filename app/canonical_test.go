@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/story", "https://example.com/story"},
+		{"http://www.Example.com/story/", "https://example.com/story"},
+		{"https://example.com/story?utm_source=twitter&utm_medium=social", "https://example.com/story"},
+		{"https://example.com/story?id=2&fbclid=abc&a=1", "https://example.com/story?a=1&id=2"},
+		{"https://example.com/story#comments", "https://example.com/story"},
+		{"https://amp.example.com/story", "https://example.com/story"},
+		{"https://example.com/story/amp/", "https://example.com/story"},
+		{"https://example.com/amp/story", "https://example.com/story"},
+		{"https://example.com/story.amp", "https://example.com/story"},
+		{"https://example.com/story.amp.html", "https://example.com/story.html"},
+		{"https://example.com/story?outputType=amp", "https://example.com/story"},
+		{"https://example.com:443/story", "https://example.com/story"},
+		{" not a url ", "not a url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(CanonicalURL(tt.url), tt.want)
+		})
+	}
+}
+
+func TestFindStory(t *testing.T) {
+	is := is.New(t)
+
+	candidates := []*Article{
+		{ID: 1, StoryID: 1, Title: "Supreme Court blocks Trump from ending DACA program"},
+		{ID: 2, StoryID: 2, Title: "Judge orders DACA applications to be accepted", CanonicalURL: "https://example.com/judge"},
+	}
+
+	story := func(title, canonicalURL string) int {
+		return findStory(&Article{Title: title, CanonicalURL: canonicalURL}, candidates)
+	}
+
+	is.Equal(story("Supreme Court blocks Trump from ending DACA program - USA Today", ""), 1) // Similar title
+	is.Equal(story("Supreme Court Blocks Trump From Ending DACA Program | Newsweek", ""), 1)  // Case and source name
+	is.Equal(story("Judge rules on DACA", "https://example.com/judge"), 2)                    // Same canonical url
+	is.Equal(story("Citizenship bill introduced", "https://example.com/citizenship"), 0)      // New story
+}
+
+func TestGetArticles_Stories(t *testing.T) {
+	is := is.New(t)
+
+	previousPageSize := PageSize
+	t.Cleanup(func() { PageSize = previousPageSize })
+	PageSize = 2
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+
+	published := time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC)
+	articles := []*Article{
+		{Title: "Supreme Court blocks Trump from ending DACA program", Source: "abc-news", PublishedAt: published.Add(48 * time.Hour)},
+		{Title: "Judge orders DACA applications to be accepted", Source: "cnn", PublishedAt: published.Add(40 * time.Hour)},
+		{Title: "Economy update", Source: "bloomberg", PublishedAt: published.Add(30 * time.Hour)},
+		{Title: "Supreme Court blocks Trump from ending DACA program - USA Today", Source: "usa-today", PublishedAt: published.Add(24 * time.Hour)},
+		{Title: "Citizenship bill introduced", Source: "the-hill", PublishedAt: published},
+	}
+	for i, article := range articles {
+		article.URL = fmt.Sprintf("https://example.com/%v", i)
+		article.CreatedAt = published
+	}
+	result, err := db.InsertArticles(context.Background(), articles)
+	is.NoErr(err)
+	is.Equal(result.Failed(), 0)
+
+	page := getArticles(t, db, ArticleQuery{Stories: true})
+	is.Equal(articleTitles(page.Articles), []string{articles[0].Title, articles[1].Title})
+	is.Equal(len(page.Articles[0].Duplicates), 1)
+	is.Equal(page.Articles[0].Duplicates[0].Source, "usa-today") // Copies are grouped under the article
+
+	page = getArticles(t, db, ArticleQuery{Stories: true, Before: page.NextCursor})
+	is.Equal(articleTitles(page.Articles), []string{"Economy update", "Citizenship bill introduced"}) // Copies are not listed on the next page
+
+	page = getArticles(t, db, ArticleQuery{Stories: true, Text: "supreme"})
+	is.Equal(len(page.Articles), 1) // Search results are grouped
+	is.Equal(len(page.Articles[0].Duplicates), 1)
+
+	page = getArticles(t, db, ArticleQuery{Before: getArticles(t, db, ArticleQuery{}).NextCursor})
+	is.Equal(page.Articles[1].Source, "usa-today") // Without stories, every article is listed
+}
+
+func TestTitleSimilarity(t *testing.T) {
+	is := is.New(t)
+
+	similarity := func(a, b string) float64 {
+		return jaccard(titleShingles(a), titleShingles(b))
+	}
+
+	is.Equal(similarity("DACA recipients win in court", "DACA recipients win in court - AP"), 1.0)
+	is.True(similarity("DACA recipients win in court", "DACA recipients win in federal court") >= StorySimilarity)
+	is.True(similarity("DACA recipients win in court", "DACA recipients lose in court") < StorySimilarity)
+}
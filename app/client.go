@@ -1,26 +1,45 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// NewsAPIPageSize is the maximum number of results NewsAPI returns per page.
+const NewsAPIPageSize int = 100
+
+// NewsAPIMaxPages is the default number of pages fetched per query.
+const NewsAPIMaxPages int = 5
+
+// NewsAPIMaxRetries is the default number of times a failed request is retried.
+const NewsAPIMaxRetries int = 3
+
+// NewsAPIRetryBackoff is the default delay before the first retry.
+const NewsAPIRetryBackoff = 2 * time.Second
+
+// newsAPIMaxResponseSize is the largest response body which is read. A page
+// of 100 results is well under it.
+const newsAPIMaxResponseSize = 10 << 20
+
 // NewNewsAPIClient creates a new api client for News API.
+// The api key is read from the NEWS_API_KEY env var.
 func NewNewsAPIClient() *NewsAPIClient {
-	apiKey := os.Getenv("NEWS_API_KEY")
-	if apiKey == "" {
-		log.Fatal("NEWS_API_KEY is not set")
-	}
 	return &NewsAPIClient{
-		APIKey:  apiKey,
-		BaseURL: "https://newsapi.org/v2",
+		APIKey:       os.Getenv("NEWS_API_KEY"),
+		BaseURL:      "https://newsapi.org/v2",
+		MaxPages:     NewsAPIMaxPages,
+		MaxRetries:   NewsAPIMaxRetries,
+		RetryBackoff: NewsAPIRetryBackoff,
 		Sources: []string{
 			"abc-news", "bloomberg", "cbs-news",
 			"cnn", "fox-news", "google-news",
@@ -35,16 +54,33 @@ func NewNewsAPIClient() *NewsAPIClient {
 
 // NewsAPIClient is an api client for NewsAPI.
 type NewsAPIClient struct {
-	APIKey  string
-	BaseURL string
-	Sources []string
+	APIKey       string
+	BaseURL      string
+	MaxPages     int
+	Sources      []string
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// sleep waits between retries, until the context is done. It is replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// Name of the source.
+func (n *NewsAPIClient) Name() string {
+	return "newsapi"
 }
 
 // GetArticles fetches new articles with the given params.
-func (n *NewsAPIClient) GetArticles(q string, from, to time.Time) ([]*Article, error) {
-	u, err := url.Parse(n.BaseURL + "/everything")
-	if err != nil {
-		log.Fatalf("Error parsing url: %v\n", err)
+// Pages are fetched until all the results are read, or the MaxPages cap is hit.
+// The result cap of the plan, such as the 100 results of the developer plan, ends
+// the results. When a later page fails, the articles of the pages which were read
+// are returned along with the error. The pages read and the total results are
+// reported in the result of the source, see reportPages.
+//
+// The requests and the retries stop when the context is done.
+func (n *NewsAPIClient) GetArticles(ctx context.Context, q string, from, to time.Time) ([]*Article, error) {
+	if n.APIKey == "" {
+		return nil, ErrNewsAPIKeyNotSet
 	}
 
 	// Prepare url params.
@@ -54,41 +90,226 @@ func (n *NewsAPIClient) GetArticles(q string, from, to time.Time) ([]*Article, e
 	params.Add("to", to.Format("2006-01-02"))
 	params.Add("language", "en")
 	params.Add("sortBy", "relevancy")
-	params.Add("pageSize", "100")
+	params.Add("pageSize", strconv.Itoa(NewsAPIPageSize))
 	params.Add("sources", strings.Join(n.Sources, ","))
 	params.Add("apiKey", n.APIKey)
+
+	articles := []*Article{}
+	totalResults := 0
+	page := 0
+	defer func() { reportPages(ctx, page, totalResults) }()
+
+	for page < n.MaxPages || n.MaxPages <= 0 {
+		page++
+		params.Set("page", strconv.Itoa(page))
+
+		apiResponse, err := n.getPage(ctx, params)
+		if errors.Is(err, ErrNewsAPIMaxResults) {
+			fmt.Printf("[client] page %v, %v\n", page, err)
+			page--
+			break
+		}
+		if err != nil && page > 1 {
+			fmt.Printf("[client] page %v failed, keeping %v results\n", page, len(articles))
+			page--
+			return articles, err
+		}
+		if err != nil {
+			page--
+			return nil, err
+		}
+
+		// Transform api response into a slice of *Article objects.
+		articles = append(articles, apiResponse.transform()...)
+		totalResults = apiResponse.TotalResults
+
+		// Stop when the results are exhausted.
+		if len(apiResponse.Articles) < NewsAPIPageSize || len(articles) >= totalResults {
+			break
+		}
+	}
+
+	fmt.Printf("[client] fetched %v pages, %v of %v results\n", page, len(articles), totalResults)
+
+	return articles, nil
+}
+
+// getPage requests a single page from the 'everything' endpoint.
+// Rate limited and server errors are retried with exponential backoff.
+func (n *NewsAPIClient) getPage(ctx context.Context, params url.Values) (*NewsAPIResponse, error) {
+	sleep := n.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+
+	for attempt := 0; ; attempt++ {
+		apiResponse, err := n.requestPage(ctx, params)
+		if err == nil {
+			return apiResponse, nil
+		}
+
+		apiErr, ok := err.(*NewsAPIError)
+		if !ok || !apiErr.Temporary() || attempt >= n.MaxRetries {
+			return nil, err
+		}
+
+		delay := n.backoff(attempt, apiErr.RetryAfter)
+		fmt.Printf("[client] %v, retrying in %v\n", err, delay)
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleepContext waits for the duration, or until the context is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns the delay before the next retry. The delay doubles
+// with each attempt, and a random jitter is added to spread out retries.
+func (n *NewsAPIClient) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	base := n.RetryBackoff
+	if base <= 0 {
+		base = NewsAPIRetryBackoff
+	}
+	delay := base * time.Duration(1<<uint(attempt))
+	delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+
+	// Respect the server's 'Retry-After' header, if it asks for longer.
+	if retryAfter > delay {
+		return retryAfter
+	}
+	return delay
+}
+
+// requestPage makes a single request to the 'everything' endpoint.
+func (n *NewsAPIClient) requestPage(ctx context.Context, params url.Values) (*NewsAPIResponse, error) {
+	u, err := url.Parse(n.BaseURL + "/everything")
+	if err != nil {
+		return nil, err
+	}
 	u.RawQuery = params.Encode()
 
 	// Make request.
-	// Returns HTTP 426 when date range is too far back.
-	res, err := http.Get(u.String())
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		fmt.Printf("[client] Error making request | %T", err)
 		return nil, err
 	}
+	defer res.Body.Close()
 
-	fmt.Printf("[client] got status code: %v\n", res.StatusCode)
+	fmt.Printf("[client] page %v, got status code: %v\n", params.Get("page"), res.StatusCode)
 
 	// Read the response body.
-	data, err := ioutil.ReadAll(res.Body)
+	data, err := io.ReadAll(io.LimitReader(res.Body, newsAPIMaxResponseSize))
 	if err != nil {
 		fmt.Println("Error reading the response body")
 		return nil, err
 	}
-	res.Body.Close()
+
+	// Error responses have a different format than successful responses.
+	if res.StatusCode != http.StatusOK {
+		return nil, newNewsAPIError(res, data)
+	}
 
 	// Unmarshal the JSON data.
-	apiResponse := NewsAPIResponse{}
-	err = json.Unmarshal(data, &apiResponse)
+	apiResponse := &NewsAPIResponse{}
+	err = json.Unmarshal(data, apiResponse)
 	if err != nil {
 		fmt.Println("Error unmarshalling the json data")
 		return nil, err
 	}
 
-	// Transform api response into a slice of *Article objects.
-	articles := apiResponse.transform()
+	if apiResponse.Status == "error" {
+		return nil, newNewsAPIError(res, data)
+	}
 
-	return articles, nil
+	return apiResponse, nil
+}
+
+// Errors returned by NewsAPI. These can be compared
+// against a *NewsAPIError with errors.Is.
+var (
+	ErrNewsAPIKeyNotSet    = errors.New("newsapi: NEWS_API_KEY is not set")
+	ErrNewsAPIUnauthorized = errors.New("newsapi: unauthorized")
+	ErrNewsAPITooFarBack   = errors.New("newsapi: date range too far back")
+	ErrNewsAPIMaxResults   = errors.New("newsapi: maximum results reached")
+	ErrNewsAPIRateLimited  = errors.New("newsapi: rate limited")
+	ErrNewsAPIServer       = errors.New("newsapi: server error")
+)
+
+// newsAPICodeMaxResults is the error code of a page past the result cap of the plan.
+// It is sent with the same 426 status code as a date range which is too far back.
+const newsAPICodeMaxResults = "maximumResultsReached"
+
+// NewsAPIError is an error response from News API.
+/* {
+    "status": "error",
+    "code": "apiKeyInvalid",
+    "message": "Your API key is invalid or incorrect."
+}
+*/
+type NewsAPIError struct {
+	StatusCode int           `json:"-"`
+	RetryAfter time.Duration `json:"-"`
+	Status     string        `json:"status"`
+	Code       string        `json:"code"`
+	Message    string        `json:"message"`
+}
+
+// newNewsAPIError builds a *NewsAPIError from an error response.
+func newNewsAPIError(res *http.Response, data []byte) *NewsAPIError {
+	apiErr := &NewsAPIError{}
+	if err := json.Unmarshal(data, apiErr); err != nil {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	apiErr.StatusCode = res.StatusCode
+
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	return apiErr
+}
+
+func (e *NewsAPIError) Error() string {
+	return fmt.Sprintf("newsapi: %v %v: %v", e.StatusCode, e.Code, e.Message)
+}
+
+// Is maps the status code to one of the ErrNewsAPI* errors.
+func (e *NewsAPIError) Is(target error) bool {
+	switch target {
+	case ErrNewsAPIUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNewsAPITooFarBack:
+		return e.StatusCode == http.StatusUpgradeRequired && e.Code != newsAPICodeMaxResults
+	case ErrNewsAPIMaxResults:
+		return e.Code == newsAPICodeMaxResults
+	case ErrNewsAPIRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrNewsAPIServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// Temporary reports whether the request can be retried.
+// Bad api keys and dates too far back will never succeed.
+func (e *NewsAPIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
 }
 
 type sourceJSON struct {
@@ -96,8 +317,13 @@ type sourceJSON struct {
 }
 
 type articleJSON struct {
-	Article
-	Source sourceJSON `json:"source"`
+	Source      sourceJSON `json:"source"`
+	Author      string     `json:"author"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	URL         string     `json:"url"`
+	URLToImage  string     `json:"urlToImage"`
+	PublishedAt time.Time  `json:"publishedAt"`
 }
 
 // NewsAPIResponse is the response format for News API.
@@ -136,7 +362,7 @@ func (n NewsAPIResponse) transform() []*Article {
 			Description: articleJSON.Description,
 			Source:      articleJSON.Source.Name,
 			Author:      articleJSON.Author,
-			LedeImg:     articleJSON.LedeImg,
+			LedeImg:     articleJSON.URLToImage,
 			PublishedAt: articleJSON.PublishedAt,
 			CreatedAt:   time.Now().UTC(),
 		}
@@ -0,0 +1,274 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+// Test Helpers
+
+// newFakeNewsAPI creates a fake NewsAPI server which pages through `total` results.
+// The number of requests made to the server is tracked in `requests`.
+func newFakeNewsAPI(total int, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+
+		articles := []map[string]interface{}{}
+		for i := (page - 1) * pageSize; i < page*pageSize && i < total; i++ {
+			articles = append(articles, map[string]interface{}{
+				"source":      map[string]string{"id": "cnn"},
+				"title":       fmt.Sprintf("Article %v", i),
+				"url":         fmt.Sprintf("https://example.com/%v", i),
+				"publishedAt": "2020-06-25T23:44:16Z",
+			})
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":       "ok",
+			"totalResults": total,
+			"articles":     articles,
+		})
+	}))
+}
+
+func newTestNewsAPIClient(baseURL string, maxPages int) *NewsAPIClient {
+	return &NewsAPIClient{APIKey: "test", BaseURL: baseURL, MaxPages: maxPages}
+}
+
+// ------------------------------------------------------------------
+
+func TestNewsAPIClient_Pagination(t *testing.T) {
+	is := is.New(t)
+
+	requests := 0
+	ts := newFakeNewsAPI(250, &requests)
+	defer ts.Close()
+
+	articles, err := newTestNewsAPIClient(ts.URL, 5).GetArticles(context.Background(), "DACA", time.Now(), time.Now())
+
+	is.NoErr(err)
+	is.Equal(requests, 3)        // Three pages were requested
+	is.Equal(len(articles), 250) // All results were fetched
+	is.Equal(articles[249].URL, "https://example.com/249")
+}
+
+func TestNewsAPIClient_PaginationExactPage(t *testing.T) {
+	is := is.New(t)
+
+	requests := 0
+	ts := newFakeNewsAPI(100, &requests)
+	defer ts.Close()
+
+	articles, err := newTestNewsAPIClient(ts.URL, 5).GetArticles(context.Background(), "DACA", time.Now(), time.Now())
+
+	is.NoErr(err)
+	is.Equal(requests, 1)        // Stops once totalResults is reached
+	is.Equal(len(articles), 100) // All results were fetched
+}
+
+func TestNewsAPIClient_PaginationMaxPages(t *testing.T) {
+	is := is.New(t)
+
+	requests := 0
+	ts := newFakeNewsAPI(1000, &requests)
+	defer ts.Close()
+
+	articles, err := newTestNewsAPIClient(ts.URL, 2).GetArticles(context.Background(), "DACA", time.Now(), time.Now())
+
+	is.NoErr(err)
+	is.Equal(requests, 2)        // Stops at the page cap
+	is.Equal(len(articles), 200) // Only the capped pages were fetched
+}
+
+func TestNewsAPIClient_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		code       string
+		target     error
+		requests   int
+	}{
+		{"unauthorized", http.StatusUnauthorized, "apiKeyInvalid", ErrNewsAPIUnauthorized, 1},
+		{"too far back", http.StatusUpgradeRequired, "parameterInvalid", ErrNewsAPITooFarBack, 1},
+		{"rate limited", http.StatusTooManyRequests, "rateLimited", ErrNewsAPIRateLimited, 3},
+		{"server error", http.StatusInternalServerError, "unexpectedError", ErrNewsAPIServer, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(tt.statusCode)
+				fmt.Fprintf(w, `{"status":"error","code":"%v","message":"Something went wrong."}`, tt.code)
+			}))
+			defer ts.Close()
+
+			client := newTestNewsAPIClient(ts.URL, 1)
+			client.MaxRetries = 2
+			client.sleep = func(context.Context, time.Duration) error { return nil }
+
+			_, err := client.GetArticles(context.Background(), "DACA", time.Now(), time.Now())
+
+			is.True(errors.Is(err, tt.target)) // Typed error
+			is.Equal(requests, tt.requests)    // Only temporary errors are retried
+
+			apiErr := err.(*NewsAPIError)
+			is.Equal(apiErr.Code, tt.code)
+			is.Equal(apiErr.Message, "Something went wrong.")
+		})
+	}
+}
+
+func TestNewsAPIClient_ReportsPages(t *testing.T) {
+	is := is.New(t)
+
+	requests := 0
+	ts := newFakeNewsAPI(250, &requests)
+	defer ts.Close()
+
+	source := newTestNewsAPIClient(ts.URL, 2)
+	articles, results := FetchFromSources(context.Background(), []Source{source}, "DACA", time.Now(), time.Now())
+
+	is.Equal(len(articles), 200)
+	is.Equal(results[0].Pages, 2)     // The pages read are reported
+	is.Equal(results[0].Results, 250) // The total results are reported
+	is.Equal(results[0].String(), "newsapi: fetched 200 articles from 2 pages, of 250 results")
+
+	tasklog := (&UpdateResult{Sources: results}).TaskLog(false)
+	is.Equal(tasklog.SourceStats()[0].Pages, 2) // The pages are recorded in the task log
+	is.Equal(tasklog.SourceStats()[0].Results, 250)
+}
+
+func TestNewsAPIClient_LaterPageFails(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		code       string
+		target     error
+	}{
+		{"maximum results", http.StatusUpgradeRequired, "maximumResultsReached", nil},
+		{"unauthorized", http.StatusUnauthorized, "apiKeyInvalid", ErrNewsAPIUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			requests := 0
+			fake := newFakeNewsAPI(250, &requests)
+			defer fake.Close()
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("page") == "2" {
+					w.WriteHeader(tt.statusCode)
+					fmt.Fprintf(w, `{"status":"error","code":"%v","message":"Something went wrong."}`, tt.code)
+					return
+				}
+				fake.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer ts.Close()
+
+			articles, err := newTestNewsAPIClient(ts.URL, 5).GetArticles(context.Background(), "DACA", time.Now(), time.Now())
+
+			is.Equal(len(articles), 100) // The first page is kept
+			if tt.target == nil {
+				is.NoErr(err) // The result cap ends the results
+				return
+			}
+			is.True(errors.Is(err, tt.target))
+		})
+	}
+}
+
+func TestNewsAPIError_MaximumResults(t *testing.T) {
+	is := is.New(t)
+
+	err := &NewsAPIError{StatusCode: http.StatusUpgradeRequired, Code: "maximumResultsReached"}
+
+	is.True(errors.Is(err, ErrNewsAPIMaxResults))
+	is.True(!errors.Is(err, ErrNewsAPITooFarBack)) // Not mistaken for a date range error
+}
+
+func TestNewsAPIClient_RetrySucceeds(t *testing.T) {
+	is := is.New(t)
+
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"status":"error","code":"rateLimited","message":"Slow down."}`)
+			return
+		}
+		fmt.Fprint(w, `{"status":"ok","totalResults":1,"articles":[{"url":"https://example.com/1"}]}`)
+	}))
+	defer ts.Close()
+
+	delays := []time.Duration{}
+	client := newTestNewsAPIClient(ts.URL, 1)
+	client.MaxRetries = 2
+	client.RetryBackoff = time.Second
+	client.sleep = func(ctx context.Context, d time.Duration) error { delays = append(delays, d); return nil }
+
+	articles, err := client.GetArticles(context.Background(), "DACA", time.Now(), time.Now())
+
+	is.NoErr(err)
+	is.Equal(len(articles), 1)                                        // Articles from the retried request
+	is.Equal(len(delays), 1)                                          // Slept once before retrying
+	is.True(delays[0] >= time.Second && delays[0] <= 3*time.Second/2) // Backoff with jitter
+}
+
+func TestNewsAPIClient_Cancelled(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+	}{
+		{"while requesting", http.StatusOK},
+		{"while waiting to retry", http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.statusCode == http.StatusOK {
+					<-r.Context().Done() // The response never comes
+					return
+				}
+				w.WriteHeader(tt.statusCode)
+				fmt.Fprint(w, `{"status":"error","code":"rateLimited","message":"Slow down."}`)
+			}))
+			defer ts.Close()
+
+			client := newTestNewsAPIClient(ts.URL, 1)
+			client.MaxRetries = 2
+			client.RetryBackoff = time.Hour
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			started := time.Now()
+			_, err := client.GetArticles(ctx, "DACA", time.Now(), time.Now())
+
+			is.True(errors.Is(err, context.DeadlineExceeded)) // The fetch stops with the context
+			is.True(time.Since(started) < 5*time.Second)      // without waiting for the server or the backoff
+		})
+	}
+}
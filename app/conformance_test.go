@@ -0,0 +1,567 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+// Test Helpers
+
+// testPostgresDSNEnv is the env var with the DSN of a postgres database
+// to run the conformance tests against. The database is reset by the tests.
+//
+//	TEST_POSTGRES_DSN=postgres://postgres@localhost/dacabot_test?sslmode=disable go test ./...
+const testPostgresDSNEnv = "TEST_POSTGRES_DSN"
+
+// newTestPostgresDB connects to the test postgres database, and resets its schema.
+// The test is skipped when no test database is configured.
+func newTestPostgresDB(t *testing.T) *PostgresDB {
+	dsn := os.Getenv(testPostgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%v is not set", testPostgresDSNEnv)
+	}
+
+	db, err := NewPostgresDB(dsn)
+	if err != nil {
+		t.Fatalf("could not connect to the test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.MigrateDown(0); err != nil {
+		t.Fatalf("could not reset the test database: %v", err)
+	}
+	return db
+}
+
+// testDatabases runs the test against each implementation of Database.
+// Each database is empty and fully migrated.
+func testDatabases(t *testing.T, test func(t *testing.T, db Database)) {
+	databases := map[string]func(t *testing.T) Database{
+		"sqlite":   func(t *testing.T) Database { return newTestDB(t) },
+		"postgres": func(t *testing.T) Database { return newTestPostgresDB(t) },
+	}
+
+	for _, name := range []string{"sqlite", "postgres"} {
+		newDB := databases[name]
+		t.Run(name, func(t *testing.T) {
+			db := newDB(t)
+			if err := db.Migrate(); err != nil {
+				t.Fatalf("could not migrate the database: %v", err)
+			}
+			test(t, db)
+		})
+	}
+}
+
+// ------------------------------------------------------------------
+
+func TestConformance_Migrations(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+
+		m, ok := db.(Migrator)
+		is.True(ok) // Every database supports migrations
+
+		statuses, err := m.MigrationStatus()
+		is.NoErr(err)
+		for _, status := range statuses {
+			is.True(status.Applied())
+		}
+
+		migrated, err := m.MigrateUp()
+		is.NoErr(err)
+		is.Equal(len(migrated), 0) // Migrating twice is a no-op
+		is.NoErr(db.CheckHealth(context.Background()))
+	})
+}
+
+func TestConformance_InsertArticle(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+
+		published := time.Date(2020, 6, 25, 23, 44, 16, 0, time.UTC)
+		article := &Article{
+			URL:         "https://example.com/1",
+			Title:       "Supreme Court blocks DACA repeal",
+			Source:      "cnn",
+			PublishedAt: published,
+			CreatedAt:   published,
+		}
+
+		ctx := context.Background()
+		id, err := db.InsertArticle(ctx, article)
+		is.NoErr(err)
+		is.True(id > 0)
+
+		_, err = db.InsertArticle(ctx, article)
+		is.True(err != nil) // URLs are unique
+
+		saved, err := db.InsertArticles(ctx, []*Article{article, {URL: "https://example.com/2", Title: "Two", Source: "cnn", PublishedAt: published.Add(time.Hour), CreatedAt: published}})
+		is.NoErr(err)
+		is.Equal(saved.Inserted(), 1) // Duplicates are not inserted again
+		is.Equal(saved.Unchanged, 1)
+
+		page := getArticles(t, db, ArticleQuery{})
+		is.Equal(len(page.Articles), 2)
+		is.Equal(page.Articles[1].ID, id)
+		is.True(page.Articles[1].PublishedAt.Equal(published))
+
+		sources, err := db.GetArticleSources(ctx)
+		is.NoErr(err)
+		is.Equal(sources, []string{"cnn"})
+	})
+}
+
+func TestConformance_InsertArticlesUpsert(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+
+		published := time.Date(2020, 6, 25, 0, 0, 0, 0, time.UTC)
+		newArticle := func(n int, img string) *Article {
+			return &Article{
+				URL:         fmt.Sprintf("https://example.com/%v", n),
+				Title:       fmt.Sprintf("Article %v", n),
+				Description: "Some description",
+				Source:      "cnn",
+				Author:      "Jane Doe",
+				LedeImg:     img,
+				PublishedAt: published.Add(time.Duration(n) * time.Hour),
+				CreatedAt:   published,
+			}
+		}
+
+		ctx := context.Background()
+		saved, err := db.InsertArticles(ctx, []*Article{newArticle(1, "one.png"), newArticle(2, "two.png")})
+		is.NoErr(err)
+		is.Equal(saved.Inserted(), 2)
+		is.Equal(saved.InsertedIDs[0], 1)
+
+		changed := newArticle(1, "one-v2.png")
+		changed.Description = ""
+		saved, err = db.InsertArticles(ctx, []*Article{
+			changed,           // The image changed
+			newArticle(2, ""), // Empty values do not overwrite stored values
+			newArticle(3, "three.png"),
+		})
+		is.NoErr(err)
+		is.Equal(saved.Inserted(), 1)
+		is.Equal(saved.Updated, 1)
+		is.Equal(saved.Unchanged, 1)
+		is.Equal(saved.Failed(), 0)
+		is.Equal(changed.ID, 1) // Ids are set on existing articles
+
+		page := getArticles(t, db, ArticleQuery{Sort: SortDate})
+		is.Equal(len(page.Articles), 3)
+		is.Equal(page.Articles[2].LedeImg, "one-v2.png")
+		is.Equal(page.Articles[2].Description, "Some description")
+		is.Equal(page.Articles[1].LedeImg, "two.png")
+	})
+}
+
+func TestConformance_InsertArticlesCanonicalURL(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+
+		published := time.Date(2020, 6, 25, 0, 0, 0, 0, time.UTC)
+		article := &Article{URL: "https://www.example.com/story/", Title: "Story", Source: "cnn", PublishedAt: published, CreatedAt: published}
+
+		ctx := context.Background()
+		saved, err := db.InsertArticles(ctx, []*Article{article})
+		is.NoErr(err)
+		is.Equal(saved.Inserted(), 1)
+		is.Equal(article.CanonicalURL, "https://example.com/story")
+
+		variant := &Article{URL: "http://example.com/story/amp?utm_source=twitter", Title: "Story", Source: "cnn", LedeImg: "story.png", PublishedAt: published, CreatedAt: published}
+		saved, err = db.InsertArticles(ctx, []*Article{variant})
+		is.NoErr(err)
+		is.Equal(saved.Inserted(), 0) // Variants of the url are not inserted again
+		is.Equal(saved.Updated, 1)
+		is.Equal(variant.ID, article.ID)
+
+		page := getArticles(t, db, ArticleQuery{})
+		is.Equal(len(page.Articles), 1)
+		is.Equal(page.Articles[0].URL, "https://www.example.com/story/") // The first url is kept
+		is.Equal(page.Articles[0].LedeImg, "story.png")
+	})
+}
+
+func TestConformance_FetchArticlesTopics(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+
+		published := time.Date(2020, 6, 25, 0, 0, 0, 0, time.UTC)
+		source := func() []Source {
+			return []Source{&fakeSource{name: "one", articles: []*Article{
+				{URL: "https://example.com/story", Title: "DACA and TPS holders rally", Source: "cnn", PublishedAt: published, CreatedAt: published},
+			}}}
+		}
+
+		ctx := context.Background()
+		for _, topic := range []*Topic{DefaultTopic, tpsTopic} {
+			result := fetchArticles(ctx, db, source(), topic, published, published)
+			is.Equal(result.Status(), TaskStatusSuccess)
+			is.Equal(result.Saved.Inserted(), 1) // The article is saved for each topic
+		}
+
+		result := fetchArticles(ctx, db, source(), tpsTopic, published, published)
+		is.Equal(result.Saved.Inserted(), 0) // Fetching a topic again does not duplicate it
+		is.Equal(result.Saved.Unchanged, 1)
+
+		for _, topic := range []*Topic{DefaultTopic, tpsTopic} {
+			page := getArticles(t, db, ArticleQuery{Topic: topic.Slug})
+			is.Equal(len(page.Articles), 1) // The article is listed in each topic
+			is.Equal(page.Articles[0].URL, "https://example.com/story")
+		}
+	})
+}
+
+func TestConformance_GetArticlesPagination(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+
+		published := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+		articles := []*Article{}
+		for i := 0; i < PageSize+2; i++ {
+			articles = append(articles, &Article{
+				URL:         fmt.Sprintf("https://example.com/%v", i),
+				Title:       fmt.Sprintf("Article %v", i),
+				Description: strings.Repeat("daca ", i%4+1),
+				Source:      "cnn",
+				PublishedAt: published.AddDate(0, 0, i),
+				CreatedAt:   published,
+			})
+		}
+		db.InsertArticles(context.Background(), articles)
+
+		for _, sort := range []string{SortDate, SortRelevance} {
+			query := ArticleQuery{Sort: sort}
+			if sort == SortRelevance {
+				query.Text = "daca"
+			}
+
+			first := getArticles(t, db, query)
+			is.Equal(len(first.Articles), PageSize)
+			is.True(first.HasMore)
+
+			query.Before = first.NextCursor
+			second := getArticles(t, db, query)
+			is.Equal(len(second.Articles), 2)
+			is.True(!second.HasMore)
+
+			seen := map[int]bool{}
+			for _, article := range append(first.Articles, second.Articles...) {
+				is.True(!seen[article.ID]) // No article is returned twice
+				seen[article.ID] = true
+			}
+		}
+
+		// Newest first.
+		page := getArticles(t, db, ArticleQuery{Sort: SortDate})
+		is.Equal(page.Articles[0].Title, fmt.Sprintf("Article %v", PageSize+1))
+		is.Equal(page.NextCursor, published.AddDate(0, 0, 2).Format(CursorFormat))
+	})
+}
+
+func TestConformance_Search(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+		insertSearchArticles(db)
+
+		search := func(query ArticleQuery) []string {
+			query.Sort = SortDate
+			return articleTitles(getArticles(t, db, query).Articles)
+		}
+
+		// Descriptions and authors are searched.
+		is.Equal(search(ArticleQuery{Text: "dreamer"}), []string{"Citizenship bill introduced", "Dreamer graduates", "Supreme Court blocks DACA repeal"})
+		is.Equal(search(ArticleQuery{Text: "celebrating"}), []string{"Dreamer graduates"})
+		is.Equal(search(ArticleQuery{Text: "Jane"}), []string{"Dreamer graduates"})
+
+		// Filters.
+		is.Equal(search(ArticleQuery{Sources: []string{"cnn", "the-hill"}}), []string{"Citizenship bill introduced", "Supreme Court blocks DACA repeal"})
+		is.Equal(search(ArticleQuery{Author: "jane"}), []string{"Dreamer graduates"})
+
+		from := time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC)
+		to := time.Date(2020, 6, 3, 0, 0, 0, 0, time.UTC)
+		is.Equal(search(ArticleQuery{From: from, To: to}), []string{"Citizenship bill introduced", "Dreamer graduates"})
+		is.Equal(search(ArticleQuery{Text: "dreamer", From: from}), []string{"Citizenship bill introduced", "Dreamer graduates"})
+		is.Equal(search(ArticleQuery{From: from, Before: "2020-06-03 00:00:00"}), []string{"Dreamer graduates"})
+
+		latest, err := db.GetLatestArticles(context.Background(), ArticleQuery{Text: "dreamer", Sort: SortRelevance}, 2)
+		is.NoErr(err)
+		is.Equal(articleTitles(latest), []string{"Citizenship bill introduced", "Dreamer graduates"})
+	})
+}
+
+func TestConformance_GetRecentArticles(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+
+		ctx := context.Background()
+		now := time.Now().UTC()
+		db.InsertArticles(ctx, []*Article{
+			{URL: "https://example.com/1", Title: "Recent", Source: "cnn", PublishedAt: now.Add(-time.Hour), CreatedAt: now},
+			{URL: "https://example.com/2", Title: "Old", Source: "cnn", PublishedAt: now.AddDate(0, 0, -RecentArticleThreshold-1), CreatedAt: now},
+			{URL: "https://example.com/3", Title: "Other topic", Topic: "tps", Source: "cnn", PublishedAt: now.Add(-2 * time.Hour), CreatedAt: now},
+		})
+
+		recent, err := db.GetRecentArticles(ctx, "daca")
+		is.NoErr(err)
+		is.Equal(articleTitles(recent), []string{"Recent"})
+
+		recent, err = db.GetRecentArticles(ctx, "")
+		is.NoErr(err)
+		is.Equal(articleTitles(recent), []string{"Recent", "Other topic"}) // All the topics
+
+		count, err := db.CountArticles(ctx, now.AddDate(0, 0, -RecentArticleThreshold))
+		is.NoErr(err)
+		is.Equal(count, 2)
+
+		count, err = db.CountArticles(ctx, now.AddDate(0, 0, -30))
+		is.NoErr(err)
+		is.Equal(count, 3)
+	})
+}
+
+func TestConformance_TaskLog(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+
+		started := time.Date(2020, 6, 25, 0, 0, 0, 0, time.UTC)
+		tasklog := &TaskLog{
+			Task:        TaskUpdateArticles,
+			Manual:      true,
+			Status:      TaskStatusPartial,
+			StartedAt:   started,
+			CompletedAt: started.Add(time.Minute),
+			Fetched:     10,
+			Inserted:    7,
+			Duplicates:  2,
+			Updated:     1,
+			Error:       "newsapi: boom",
+		}
+		tasklog.SetSourceStats([]TaskSourceStat{{Source: "newsapi", Error: "boom"}, {Source: "feeds", Fetched: 10}})
+		ctx := context.Background()
+		is.NoErr(db.RecordTask(ctx, tasklog))
+
+		// Failed and skipped runs are recorded, but are not the most recent sync.
+		is.NoErr(db.RecordTask(ctx, &TaskLog{Task: TaskUpdateArticles, Status: TaskStatusFailed}))
+		is.NoErr(db.RecordTask(ctx, &TaskLog{Task: TaskUpdateArticles, Status: TaskStatusSkipped}))
+
+		recent, err := db.GetRecentTaskLog(ctx, TaskUpdateArticles)
+		is.NoErr(err)
+		is.True(recent.ID > 0)
+		is.True(recent.Manual)
+		is.Equal(recent.Status, TaskStatusPartial)
+		is.Equal(recent.Duration(), time.Minute)
+		is.Equal(recent.Inserted, 7)
+		is.Equal(recent.Updated, 1)
+		is.Equal(recent.Error, "newsapi: boom")
+		is.Equal(recent.SourceStats()[1].Fetched, 10)
+
+		missing, err := db.GetRecentTaskLog(ctx, "unknown")
+		is.NoErr(err)
+		is.Equal(missing.ID, 0) // Missing tasks return an empty tasklog
+
+		is.True(tasklog.ID > 0) // Recording a task sets its id
+		recorded, err := db.GetTaskLog(ctx, tasklog.ID)
+		is.NoErr(err)
+		is.Equal(recorded.Status, TaskStatusPartial)
+		is.Equal(recorded.SourceStats()[0].Error, "boom")
+
+		_, err = db.GetTaskLog(ctx, tasklog.ID+100)
+		is.True(errors.Is(err, ErrTaskLogNotFound))
+	})
+}
+
+func TestConformance_PruneTaskLog(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+		ctx := context.Background()
+
+		now := time.Now().UTC()
+		for _, tasklog := range []*TaskLog{
+			{Task: TaskUpdateArticles, Status: TaskStatusSuccess, CompletedAt: now.AddDate(0, 0, -100)},
+			{Task: TaskUpdateArticles, Status: TaskStatusSuccess, CompletedAt: now.AddDate(0, 0, -95)},
+			{Task: TaskUpdateArticles, Status: TaskStatusFailed, CompletedAt: now.AddDate(0, 0, -91)},
+			{Task: TaskUpdateArticles, Status: TaskStatusFailed, CompletedAt: now.AddDate(0, 0, -1)},
+		} {
+			is.NoErr(db.RecordTask(ctx, tasklog))
+		}
+
+		pruned, err := db.PruneTaskLog(ctx, now.AddDate(0, 0, -90))
+		is.NoErr(err)
+		is.Equal(pruned, 2)
+
+		recent, err := db.GetRecentTaskLog(ctx, TaskUpdateArticles)
+		is.NoErr(err)
+		is.True(recent.ID > 0) // The most recent sync is kept
+	})
+}
+
+func TestConformance_BackfillWindows(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+
+		from := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+		ctx := context.Background()
+		for i, status := range []string{TaskStatusSuccess, TaskStatusFailed, TaskStatusSuccess} {
+			topic := "daca"
+			if i == 2 {
+				topic = "tps"
+			}
+			window := &BackfillWindow{
+				Topic:       topic,
+				From:        from.AddDate(0, 0, 7*i),
+				To:          from.AddDate(0, 0, 7*i+6),
+				Status:      status,
+				Fetched:     3,
+				CompletedAt: from,
+			}
+			id, err := db.InsertBackfillWindow(ctx, window)
+			is.NoErr(err)
+			is.Equal(window.ID, id)
+		}
+
+		completed, err := db.GetCompletedBackfillWindows(ctx, "daca")
+		is.NoErr(err)
+		is.Equal(len(completed), 1) // Failed windows, and windows of other topics, are not completed
+		is.True(completed[0].From.Equal(from))
+		is.True(completed[0].To.Equal(from.AddDate(0, 0, 6)))
+		is.Equal(completed[0].Fetched, 3)
+	})
+}
+
+func TestConformance_TaskLease(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+
+		now := time.Now().UTC()
+		ctx := context.Background()
+		lease := func(holder string, acquiredAt time.Time) *TaskLease {
+			return &TaskLease{Task: TaskUpdateArticles, Holder: holder, AcquiredAt: acquiredAt, ExpiresAt: acquiredAt.Add(time.Hour)}
+		}
+
+		first := lease("first", now)
+		current, err := db.AcquireLease(ctx, first)
+		is.NoErr(err)
+		is.Equal(current, first) // A free task is acquired
+
+		current, err = db.AcquireLease(ctx, lease("second", now.Add(time.Minute)))
+		is.NoErr(err)
+		is.Equal(current.Holder, "first") // A held task is not acquired
+		is.True(current.ExpiresAt.Equal(first.ExpiresAt))
+
+		other := &TaskLease{Task: "other", Holder: "second", AcquiredAt: now, ExpiresAt: now.Add(time.Hour)}
+		current, err = db.AcquireLease(ctx, other)
+		is.NoErr(err)
+		is.Equal(current, other) // Tasks are locked separately
+
+		stale := lease("third", now.Add(2*time.Hour))
+		current, err = db.AcquireLease(ctx, stale)
+		is.NoErr(err)
+		is.Equal(current, stale) // An expired lease is taken over
+
+		renewed := *stale
+		renewed.ExpiresAt = stale.ExpiresAt.Add(time.Hour)
+		is.NoErr(db.RenewLease(ctx, &renewed))
+		current, err = db.AcquireLease(ctx, lease("fourth", now.Add(3*time.Hour)))
+		is.NoErr(err)
+		is.Equal(current.Holder, "third") // A renewed lease is held until its new expiry
+		is.True(current.ExpiresAt.Equal(renewed.ExpiresAt))
+
+		err = db.RenewLease(ctx, first)
+		is.True(errors.Is(err, ErrTaskLocked)) // A lost lease is not renewed
+
+		is.NoErr(db.ReleaseLease(ctx, first)) // Releasing a lost lease is a no-op
+		current, err = db.AcquireLease(ctx, lease("fourth", now.Add(2*time.Hour)))
+		is.NoErr(err)
+		is.Equal(current.Holder, "third")
+
+		is.NoErr(db.ReleaseLease(ctx, stale))
+		current, err = db.AcquireLease(ctx, lease("fourth", now.Add(2*time.Hour)))
+		is.NoErr(err)
+		is.Equal(current.Holder, "fourth") // A released task is acquired
+	})
+}
+
+func TestConformance_Users(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+		ctx := context.Background()
+
+		_, err := db.GetUser(ctx, "alice")
+		is.True(errors.Is(err, ErrUserNotFound)) // No user yet
+
+		user := &User{Username: "alice", PasswordHash: "hash", CreatedAt: time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)}
+		id, err := db.InsertUser(ctx, user)
+		is.NoErr(err)
+		is.True(id > 0)
+
+		found, err := db.GetUser(ctx, "alice")
+		is.NoErr(err)
+		is.Equal(found.ID, id)
+		is.Equal(found.PasswordHash, "hash")
+		is.True(found.CreatedAt.Equal(user.CreatedAt))
+
+		_, err = db.InsertUser(ctx, &User{Username: "alice", PasswordHash: "other", CreatedAt: user.CreatedAt})
+		is.True(err != nil) // The usernames are unique
+
+		is.Equal(found.SessionVersion, 0)
+		is.NoErr(db.RevokeSessions(ctx, "alice"))
+		is.NoErr(db.UpdateUserPassword(ctx, "alice", "new-hash"))
+		found, err = db.GetUser(ctx, "alice")
+		is.NoErr(err)
+		is.Equal(found.PasswordHash, "new-hash")
+		is.Equal(found.SessionVersion, 2) // Each change revokes the sessions
+
+		is.True(errors.Is(db.RevokeSessions(ctx, "bob"), ErrUserNotFound))
+		is.True(errors.Is(db.UpdateUserPassword(ctx, "bob", "hash"), ErrUserNotFound))
+
+		is.NoErr(db.RevokeSession(ctx, "session-1", time.Now().Add(time.Hour)))
+		is.NoErr(db.RevokeSession(ctx, "session-1", time.Now().Add(time.Hour))) // Revoking again is a no-op
+		is.NoErr(db.RevokeSession(ctx, "session-2", time.Now().Add(-time.Hour)))
+		revoked, err := db.IsSessionRevoked(ctx, "session-1")
+		is.NoErr(err)
+		is.True(revoked)
+		revoked, err = db.IsSessionRevoked(ctx, "session-3")
+		is.NoErr(err)
+		is.True(!revoked)
+
+		is.NoErr(db.RevokeSession(ctx, "session-3", time.Now().Add(time.Hour)))
+		revoked, err = db.IsSessionRevoked(ctx, "session-2")
+		is.NoErr(err)
+		is.True(!revoked) // The expired sessions are removed
+	})
+}
+
+func TestConformance_GetArticlesTopic(t *testing.T) {
+	testDatabases(t, func(t *testing.T, db Database) {
+		is := is.New(t)
+
+		published := time.Date(2020, 6, 25, 0, 0, 0, 0, time.UTC)
+		_, err := db.InsertArticles(context.Background(), []*Article{
+			{URL: "https://example.com/1", Title: "DACA ruling", Source: "cnn", PublishedAt: published, CreatedAt: published},
+			{URL: "https://example.com/2", Title: "TPS ruling", Topic: "tps", Source: "cnn", PublishedAt: published.Add(time.Hour), CreatedAt: published},
+		})
+		is.NoErr(err)
+
+		page := getArticles(t, db, ArticleQuery{Topic: "daca"})
+		is.Equal(articleTitles(page.Articles), []string{"DACA ruling"}) // Articles without a topic are of the default topic
+		is.Equal(page.Articles[0].Topic, "daca")
+
+		page = getArticles(t, db, ArticleQuery{Topic: "tps", Text: "ruling"})
+		is.Equal(articleTitles(page.Articles), []string{"TPS ruling"})
+
+		page = getArticles(t, db, ArticleQuery{})
+		is.Equal(len(page.Articles), 2) // All the topics
+	})
+}
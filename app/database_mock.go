@@ -1,22 +1,49 @@
 package app
 
+import (
+	"context"
+	"time"
+)
+
 // MockServerDB is used in tests which require a mocked db.
 // MockServerDB implements the Database interface.
 type MockServerDB struct {
-	closeMock        func() error
-	checkHealthMock  func() error
-	createTablesMock func()
+	closeMock       func() error
+	checkHealthMock func(ctx context.Context) error
+	migrateMock     func() error
 
 	// Articles
-	getArticlesMock       func(q, pubDate string) ([]*Article, bool)
-	getRecentArticlesMock func() []*Article
-	insertArticleMock     func(article *Article) (int, error)
-	insertArticlesMock    func(articles []*Article) []int
+	getArticlesMock       func(ctx context.Context, query ArticleQuery) (*ArticlePage, error)
+	getRecentArticlesMock func(ctx context.Context, topic string) ([]*Article, error)
+	getLatestArticlesMock func(ctx context.Context, query ArticleQuery, limit int) ([]*Article, error)
+	getArticleSourcesMock func(ctx context.Context) ([]string, error)
+	countArticlesMock     func(ctx context.Context, since time.Time) (int, error)
+	insertArticleMock     func(ctx context.Context, article *Article) (int, error)
+	insertArticlesMock    func(ctx context.Context, articles []*Article) (*InsertResult, error)
 
 	// TaskLog
-	getRecentTaskLogMock func(string) *TaskLog
-	insertTaskLogMock    func(tasklog *TaskLog) (int, error)
-	recordTaskMock       func(task string, manual bool)
+	getRecentTaskLogMock func(ctx context.Context, task string) (*TaskLog, error)
+	getTaskLogMock       func(ctx context.Context, id int) (*TaskLog, error)
+	insertTaskLogMock    func(ctx context.Context, tasklog *TaskLog) (int, error)
+	recordTaskMock       func(ctx context.Context, tasklog *TaskLog) error
+	pruneTaskLogMock     func(ctx context.Context, before time.Time) (int, error)
+
+	// Backfill
+	getCompletedBackfillWindowsMock func(ctx context.Context, topic string) ([]*BackfillWindow, error)
+	insertBackfillWindowMock        func(ctx context.Context, window *BackfillWindow) (int, error)
+
+	// TaskLease
+	acquireLeaseMock func(ctx context.Context, lease *TaskLease) (*TaskLease, error)
+	renewLeaseMock   func(ctx context.Context, lease *TaskLease) error
+	releaseLeaseMock func(ctx context.Context, lease *TaskLease) error
+
+	// User
+	getUserMock            func(ctx context.Context, username string) (*User, error)
+	insertUserMock         func(ctx context.Context, user *User) (int, error)
+	updateUserPasswordMock func(ctx context.Context, username, passwordHash string) error
+	revokeSessionsMock     func(ctx context.Context, username string) error
+	revokeSessionMock      func(ctx context.Context, id string, expiresAt time.Time) error
+	isSessionRevokedMock   func(ctx context.Context, id string) (bool, error)
 }
 
 // Close is exported
@@ -25,46 +52,130 @@ func (mc *MockServerDB) Close() error {
 }
 
 // CheckHealth is exported
-func (mc *MockServerDB) CheckHealth() error {
-	return mc.checkHealthMock()
+func (mc *MockServerDB) CheckHealth(ctx context.Context) error {
+	return mc.checkHealthMock(ctx)
 }
 
-// CreateTables is exported
-func (mc *MockServerDB) CreateTables() {
-	// We don't need to return anything since there's no return value.
+// Migrate is exported
+func (mc *MockServerDB) Migrate() error {
+	return mc.migrateMock()
 }
 
 // GetArticles is exported
-func (mc *MockServerDB) GetArticles(q, pubDate string) ([]*Article, bool) {
-	return mc.getArticlesMock(q, pubDate)
+func (mc *MockServerDB) GetArticles(ctx context.Context, query ArticleQuery) (*ArticlePage, error) {
+	return mc.getArticlesMock(ctx, query)
 }
 
 // GetRecentArticles is exported
-func (mc *MockServerDB) GetRecentArticles() []*Article {
-	return mc.getRecentArticlesMock()
+func (mc *MockServerDB) GetRecentArticles(ctx context.Context, topic string) ([]*Article, error) {
+	return mc.getRecentArticlesMock(ctx, topic)
+}
+
+// GetLatestArticles is exported
+func (mc *MockServerDB) GetLatestArticles(ctx context.Context, query ArticleQuery, limit int) ([]*Article, error) {
+	return mc.getLatestArticlesMock(ctx, query, limit)
+}
+
+// GetArticleSources is exported
+func (mc *MockServerDB) GetArticleSources(ctx context.Context) ([]string, error) {
+	return mc.getArticleSourcesMock(ctx)
+}
+
+// CountArticles is exported
+func (mc *MockServerDB) CountArticles(ctx context.Context, since time.Time) (int, error) {
+	return mc.countArticlesMock(ctx, since)
 }
 
 // InsertArticle is exported
-func (mc *MockServerDB) InsertArticle(article *Article) (int, error) {
-	return mc.insertArticleMock(article)
+func (mc *MockServerDB) InsertArticle(ctx context.Context, article *Article) (int, error) {
+	return mc.insertArticleMock(ctx, article)
 }
 
 // InsertArticles is exported
-func (mc *MockServerDB) InsertArticles(articles []*Article) []int {
-	return mc.insertArticlesMock(articles)
+func (mc *MockServerDB) InsertArticles(ctx context.Context, articles []*Article) (*InsertResult, error) {
+	return mc.insertArticlesMock(ctx, articles)
 }
 
 // GetRecentTaskLog is exported
-func (mc *MockServerDB) GetRecentTaskLog(task string) *TaskLog {
-	return mc.getRecentTaskLogMock(task)
+func (mc *MockServerDB) GetRecentTaskLog(ctx context.Context, task string) (*TaskLog, error) {
+	return mc.getRecentTaskLogMock(ctx, task)
+}
+
+// GetTaskLog is exported
+func (mc *MockServerDB) GetTaskLog(ctx context.Context, id int) (*TaskLog, error) {
+	return mc.getTaskLogMock(ctx, id)
 }
 
 // InsertTaskLog is exported
-func (mc *MockServerDB) InsertTaskLog(tasklog *TaskLog) (int, error) {
-	return mc.insertTaskLogMock(tasklog)
+func (mc *MockServerDB) InsertTaskLog(ctx context.Context, tasklog *TaskLog) (int, error) {
+	return mc.insertTaskLogMock(ctx, tasklog)
 }
 
 // RecordTask is exported
-func (mc *MockServerDB) RecordTask(task string, manual bool) {
-	// We don't need to return anything since there's no return value.
+// Recording is optional in tests, so a missing mock is a no-op.
+func (mc *MockServerDB) RecordTask(ctx context.Context, tasklog *TaskLog) error {
+	if mc.recordTaskMock == nil {
+		return nil
+	}
+	return mc.recordTaskMock(ctx, tasklog)
+}
+
+// PruneTaskLog is exported
+func (mc *MockServerDB) PruneTaskLog(ctx context.Context, before time.Time) (int, error) {
+	return mc.pruneTaskLogMock(ctx, before)
+}
+
+// GetCompletedBackfillWindows is exported
+func (mc *MockServerDB) GetCompletedBackfillWindows(ctx context.Context, topic string) ([]*BackfillWindow, error) {
+	return mc.getCompletedBackfillWindowsMock(ctx, topic)
+}
+
+// InsertBackfillWindow is exported
+func (mc *MockServerDB) InsertBackfillWindow(ctx context.Context, window *BackfillWindow) (int, error) {
+	return mc.insertBackfillWindowMock(ctx, window)
+}
+
+// AcquireLease is exported
+func (mc *MockServerDB) AcquireLease(ctx context.Context, lease *TaskLease) (*TaskLease, error) {
+	return mc.acquireLeaseMock(ctx, lease)
+}
+
+// RenewLease is exported
+func (mc *MockServerDB) RenewLease(ctx context.Context, lease *TaskLease) error {
+	return mc.renewLeaseMock(ctx, lease)
+}
+
+// ReleaseLease is exported
+func (mc *MockServerDB) ReleaseLease(ctx context.Context, lease *TaskLease) error {
+	return mc.releaseLeaseMock(ctx, lease)
+}
+
+// GetUser is exported
+func (mc *MockServerDB) GetUser(ctx context.Context, username string) (*User, error) {
+	return mc.getUserMock(ctx, username)
+}
+
+// InsertUser is exported
+func (mc *MockServerDB) InsertUser(ctx context.Context, user *User) (int, error) {
+	return mc.insertUserMock(ctx, user)
+}
+
+// UpdateUserPassword is exported
+func (mc *MockServerDB) UpdateUserPassword(ctx context.Context, username, passwordHash string) error {
+	return mc.updateUserPasswordMock(ctx, username, passwordHash)
+}
+
+// RevokeSessions is exported
+func (mc *MockServerDB) RevokeSessions(ctx context.Context, username string) error {
+	return mc.revokeSessionsMock(ctx, username)
+}
+
+// RevokeSession is exported
+func (mc *MockServerDB) RevokeSession(ctx context.Context, id string, expiresAt time.Time) error {
+	return mc.revokeSessionMock(ctx, id, expiresAt)
+}
+
+// IsSessionRevoked is exported
+func (mc *MockServerDB) IsSessionRevoked(ctx context.Context, id string) (bool, error) {
+	return mc.isSessionRevokedMock(ctx, id)
 }
@@ -0,0 +1,848 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // postgres
+)
+
+// postgresParams are set on every postgres connection, unless the DSN sets them.
+// Timestamps are read in UTC, to match the times stored by sqlite.
+var postgresParams = url.Values{
+	"timezone": {"UTC"},
+}
+
+// isPostgresDSN reports whether the DSN is a postgres connection URL.
+func isPostgresDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// NewPostgresDB connects to the postgres database at the DSN.
+func NewPostgresDB(dsn string) (*PostgresDB, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database DSN: %w", err)
+	}
+	params := u.Query()
+	for key, value := range postgresParams {
+		if _, ok := params[key]; !ok {
+			params[key] = value
+		}
+	}
+	u.RawQuery = params.Encode()
+
+	db, err := sqlx.Open("postgres", u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not connect to postgres at %v: %w", u.Host, err)
+	}
+
+	return &PostgresDB{db: db}, nil
+}
+
+// PostgresDB is a Database backed by postgres.
+// Search uses the postgres full-text search, which is always available.
+type PostgresDB struct {
+	db *sqlx.DB
+}
+
+// PostgresMigrations are the schema migrations of the postgres database.
+// Never edit a migration once it is released, add a new one instead.
+var PostgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create article and tasklog",
+		Up: `
+			CREATE TABLE article (
+				id SERIAL PRIMARY KEY,
+				url TEXT UNIQUE NOT NULL,
+				title TEXT NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				source TEXT NOT NULL,
+				author TEXT NOT NULL DEFAULT '',
+				lede_img TEXT NOT NULL DEFAULT '',
+				published_at TIMESTAMPTZ NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL
+			);
+			CREATE INDEX article_published_at_idx ON article (published_at);
+
+			CREATE TABLE tasklog (
+				id SERIAL PRIMARY KEY,
+				task TEXT NOT NULL,
+				manual BOOLEAN NOT NULL DEFAULT FALSE,
+				status TEXT NOT NULL DEFAULT 'success',
+				started_at TIMESTAMPTZ NOT NULL,
+				completed_at TIMESTAMPTZ NOT NULL,
+				fetched INTEGER NOT NULL DEFAULT 0,
+				inserted INTEGER NOT NULL DEFAULT 0,
+				duplicates INTEGER NOT NULL DEFAULT 0,
+				sources TEXT NOT NULL DEFAULT '[]',
+				error TEXT NOT NULL DEFAULT ''
+			);
+			CREATE INDEX tasklog_task_idx ON tasklog (task, completed_at);`,
+		Down: `
+			DROP TABLE article;
+			DROP TABLE tasklog;`,
+	},
+	{
+		Version: 2,
+		Name:    "add article search index",
+		Up: `
+			CREATE INDEX article_search_idx ON article USING GIN ((` + pgSearchDocument + `));`,
+		Down: `
+			DROP INDEX article_search_idx;`,
+	},
+	{
+		Version: 3,
+		Name:    "add updated and failed counts to tasklog",
+		Up: `
+			ALTER TABLE tasklog ADD COLUMN updated INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE tasklog ADD COLUMN failed INTEGER NOT NULL DEFAULT 0;`,
+		Down: `
+			ALTER TABLE tasklog DROP COLUMN updated;
+			ALTER TABLE tasklog DROP COLUMN failed;`,
+	},
+	{
+		Version: 4,
+		Name:    "add canonical url to article",
+		Up: `
+			ALTER TABLE article ADD COLUMN canonical_url TEXT NOT NULL DEFAULT '';
+			CREATE INDEX article_canonical_url_idx ON article (canonical_url);`,
+		Down: `
+			ALTER TABLE article DROP COLUMN canonical_url;`,
+	},
+	{
+		Version: 5,
+		Name:    "create backfill_window",
+		Up: `
+			CREATE TABLE backfill_window (
+				id SERIAL PRIMARY KEY,
+				window_from TIMESTAMPTZ NOT NULL,
+				window_to TIMESTAMPTZ NOT NULL,
+				status TEXT NOT NULL,
+				fetched INTEGER NOT NULL DEFAULT 0,
+				inserted INTEGER NOT NULL DEFAULT 0,
+				updated INTEGER NOT NULL DEFAULT 0,
+				failed INTEGER NOT NULL DEFAULT 0,
+				error TEXT NOT NULL DEFAULT '',
+				completed_at TIMESTAMPTZ NOT NULL
+			);`,
+		Down: `
+			DROP TABLE backfill_window;`,
+	},
+	{
+		Version: 6,
+		Name:    "add topic to article and backfill_window",
+		Up: `
+			ALTER TABLE article ADD COLUMN topic TEXT NOT NULL DEFAULT 'daca';
+			CREATE INDEX article_topic_idx ON article (topic, published_at);
+			ALTER TABLE backfill_window ADD COLUMN topic TEXT NOT NULL DEFAULT 'daca';`,
+		Down: `
+			ALTER TABLE article DROP COLUMN topic;
+			ALTER TABLE backfill_window DROP COLUMN topic;`,
+	},
+	{
+		Version: 7,
+		Name:    "create task_lease",
+		Up: `
+			CREATE TABLE task_lease (
+				task TEXT PRIMARY KEY,
+				holder TEXT NOT NULL,
+				acquired_at TIMESTAMPTZ NOT NULL,
+				expires_at TIMESTAMPTZ NOT NULL
+			);`,
+		Down: `
+			DROP TABLE task_lease;`,
+	},
+	{
+		Version: 8,
+		Name:    "create admin_user",
+		Up: `
+			CREATE TABLE admin_user (
+				id SERIAL PRIMARY KEY,
+				username TEXT UNIQUE NOT NULL,
+				password_hash TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL
+			);`,
+		Down: `
+			DROP TABLE admin_user;`,
+	},
+	{
+		Version: 9,
+		Name:    "add story to article",
+		// The stories are assigned in Go, see backfillStories.
+		Up: `
+			ALTER TABLE article ADD COLUMN story_id INTEGER NOT NULL DEFAULT 0;
+			CREATE INDEX article_story_idx ON article (story_id);`,
+		Down: `
+			ALTER TABLE article DROP COLUMN story_id;`,
+	},
+	{
+		Version: 10,
+		Name:    "make article urls unique per topic",
+		// An article which matches more than one topic is saved once for each topic.
+		// Reverting keeps the article of the first topic.
+		Up: `
+			ALTER TABLE article DROP CONSTRAINT article_url_key;
+			ALTER TABLE article ADD CONSTRAINT article_url_topic_key UNIQUE (url, topic);`,
+		Down: `
+			DELETE FROM article WHERE id NOT IN (SELECT MIN(id) FROM article GROUP BY url);
+			ALTER TABLE article DROP CONSTRAINT article_url_topic_key;
+			ALTER TABLE article ADD CONSTRAINT article_url_key UNIQUE (url);`,
+	},
+	{
+		Version: 11,
+		Name:    "add session_version to admin_user",
+		// The sessions of a user are revoked by incrementing the version, see RevokeSessions.
+		Up: `
+			ALTER TABLE admin_user ADD COLUMN session_version INTEGER NOT NULL DEFAULT 0;`,
+		Down: `
+			ALTER TABLE admin_user DROP COLUMN session_version;`,
+	},
+	{
+		Version: 12,
+		Name:    "create admin_session_revoked",
+		// A signed out session is revoked until it expires, see RevokeSession.
+		Up: `
+			CREATE TABLE admin_session_revoked (
+				id VARCHAR(64) PRIMARY KEY,
+				expires_at TIMESTAMPTZ NOT NULL
+			);`,
+		Down: `
+			DROP TABLE admin_session_revoked;`,
+	},
+}
+
+// pgSearchDocument is the full-text document of an article. It is weighted
+// like the sqlite index: title, then description, then source and author.
+// Queries must use the same expression for the search index to be used.
+const pgSearchDocument = `setweight(to_tsvector('english', title), 'A') || ` +
+	`setweight(to_tsvector('english', description), 'B') || ` +
+	`setweight(to_tsvector('english', source), 'C') || ` +
+	`setweight(to_tsvector('english', author), 'D')`
+
+// pgHeadlineOptions configures the search snippets to match the sqlite snippets.
+var pgHeadlineOptions = fmt.Sprintf("StartSel=%v, StopSel=%v, MaxFragments=1, MaxWords=24, MinWords=12",
+	snippetStart, snippetEnd)
+
+// Close the db.
+func (d *PostgresDB) Close() error {
+	return d.db.Close()
+}
+
+// CheckHealth performs a db ping.
+func (d *PostgresDB) CheckHealth(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+// Migrate applies the pending schema migrations.
+func (d *PostgresDB) Migrate() error {
+	if _, err := d.MigrateUp(); err != nil {
+		return err
+	}
+	if err := backfillCanonicalURLs(d.db); err != nil {
+		return err
+	}
+	return backfillStories(d.db, pgTime)
+}
+
+// migrator returns the schema migrator of the postgres database.
+func (d *PostgresDB) migrator() *schemaMigrator {
+	return &schemaMigrator{
+		db:         d.db,
+		migrations: PostgresMigrations,
+		table: `
+			CREATE TABLE schema_migrations (
+				version INTEGER PRIMARY KEY,
+				name TEXT NOT NULL,
+				applied_at TIMESTAMPTZ NOT NULL
+			);`,
+		tableExists: d.tableExists,
+	}
+}
+
+// MigrationStatus lists all the migrations, and when they were applied.
+func (d *PostgresDB) MigrationStatus() ([]MigrationStatus, error) {
+	return d.migrator().MigrationStatus()
+}
+
+// MigrateUp applies all the pending migrations, in order.
+func (d *PostgresDB) MigrateUp() ([]Migration, error) {
+	return d.migrator().MigrateUp()
+}
+
+// MigrateDown reverts the applied migrations until the database is at the given version.
+func (d *PostgresDB) MigrateDown(version int) ([]Migration, error) {
+	return d.migrator().MigrateDown(version)
+}
+
+func (d *PostgresDB) tableExists(name string) bool {
+	count := 0
+	sql := `
+		SELECT COUNT(*)
+		FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_name = $1;`
+	d.db.Get(&count, sql, name)
+	return count > 0
+}
+
+// ------------------------------------------------------------------
+// Article
+// ------------------------------------------------------------------
+
+// GetArticles queries a page of articles from the db.
+func (d *PostgresDB) GetArticles(ctx context.Context, query ArticleQuery) (*ArticlePage, error) {
+	articles, sort, err := d.searchArticles(ctx, query, PageSize+1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Query one more row than the page size, to know if there are more results.
+	hasMoreResults := len(articles) > PageSize
+	if hasMoreResults {
+		articles = articles[:PageSize]
+	}
+
+	if query.Stories {
+		if err := attachDuplicates(ctx, d.db, query, articles, pgTime); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ArticlePage{
+		Articles:   articles,
+		NextCursor: nextCursor(articles, sort),
+		HasMore:    hasMoreResults,
+		Sort:       sort,
+	}, nil
+}
+
+// searchArticles queries articles matching the query, with the same
+// ordering and cursors as the sqlite database. The relevance rank is
+// negated, so that, like the sqlite bm25 rank, lower is better.
+func (d *PostgresDB) searchArticles(ctx context.Context, query ArticleQuery, limit int) ([]*Article, string, error) {
+	text := strings.TrimSpace(query.Text)
+	sort := query.sortOrder()
+
+	// Articles published in the future are not listed in date order.
+	now := time.Now().UTC()
+	before := now
+	if query.Before != "" && sort == SortDate {
+		cursor, err := time.Parse(CursorFormat, query.Before)
+		if err != nil {
+			return nil, sort, fmt.Errorf("could not fetch articles: %w", err)
+		}
+		before = cursor
+	}
+
+	// A search text without terms to match, such as '*', matches no articles.
+	match := ""
+	if text != "" {
+		if match = tsQuery(text); match == "" {
+			return []*Article{}, sort, nil
+		}
+	}
+
+	filters, args := query.filterSQL("a", pgTime)
+	articles := []*Article{}
+	var err error
+
+	switch {
+	case text == "":
+		matches := `
+			SELECT a.*
+			FROM article a
+			WHERE a.published_at < ?` + filters
+		sql := query.pageSQL(matches, "m.published_at < ?", "m.published_at DESC")
+		args = append([]interface{}{now}, args...)
+		err = d.db.SelectContext(ctx, &articles, d.db.Rebind(sql), append(args, before, limit)...)
+
+	case sort == SortRelevance:
+		rank, id := math.Inf(-1), 0
+		if query.Before != "" {
+			if rank, id, err = parseRelevanceCursor(query.Before); err != nil {
+				break
+			}
+		}
+		matches := `
+			SELECT a.*,
+				(-ts_rank(` + pgSearchDocument + `, tsq))::float8 AS rank,
+				ts_headline('english', a.description, tsq, ?) AS snippet
+			FROM article a, to_tsquery('english', ?) tsq
+			WHERE (` + pgSearchDocument + `) @@ tsq` + filters
+		sql := query.pageSQL(matches, "(m.rank > ? OR (m.rank = ? AND m.id > ?))", "m.rank, m.id")
+		args = append([]interface{}{pgHeadlineOptions, match}, args...)
+		err = d.db.SelectContext(ctx, &articles, d.db.Rebind(sql), append(args, rank, rank, id, limit)...)
+
+	default:
+		matches := `
+			SELECT a.*,
+				ts_headline('english', a.description, tsq, ?) AS snippet
+			FROM article a, to_tsquery('english', ?) tsq
+			WHERE (` + pgSearchDocument + `) @@ tsq AND a.published_at < ?` + filters
+		sql := query.pageSQL(matches, "m.published_at < ?", "m.published_at DESC")
+		args = append([]interface{}{pgHeadlineOptions, match, now}, args...)
+		err = d.db.SelectContext(ctx, &articles, d.db.Rebind(sql), append(args, before, limit)...)
+	}
+
+	if err != nil {
+		return nil, sort, fmt.Errorf("could not fetch articles: %w", err)
+	}
+
+	return articles, sort, nil
+}
+
+// pgTime passes the time to postgres as a timestamp.
+func pgTime(t time.Time) interface{} {
+	return t.UTC()
+}
+
+// tsQuery converts the search text into a postgres tsquery,
+// with the same syntax as ftsQuery.
+//
+// Terms are quoted, and are normalized by to_tsquery,
+// so a quoted phrase becomes a phrase query.
+func tsQuery(text string) string {
+	terms := []string{}
+	previousTerm := false
+	for _, token := range tokenizeSearch(text) {
+		term := ""
+		switch {
+		case isFTSOperator(token):
+			// Operators are only valid between two terms. Like ftsQuery, a NOT
+			// with no term before it to exclude from matches no articles.
+			switch {
+			case previousTerm:
+				terms = append(terms, map[string]string{"OR": "|", "AND": "&", "NOT": "& !"}[token])
+				previousTerm = false
+			case token == "NOT" && len(terms) > 0 && terms[len(terms)-1] == "&":
+				terms[len(terms)-1] = "& !"
+			case token == "NOT":
+				return ""
+			}
+			continue
+		case strings.HasPrefix(token, `"`):
+			if phrase := strings.Trim(token, `"`); phrase != "" {
+				term = quoteTS(phrase)
+			}
+		case strings.HasSuffix(token, "*") && isWord(strings.TrimRight(token, "*")):
+			term = quoteTS(strings.TrimRight(token, "*")) + ":*"
+		default:
+			if word := strings.Trim(token, "*"); word != "" {
+				term = quoteTS(word)
+			}
+		}
+
+		if term == "" {
+			continue
+		}
+		if previousTerm {
+			terms = append(terms, "&")
+		}
+		terms = append(terms, term)
+		previousTerm = true
+	}
+
+	// Drop a trailing operator.
+	if len(terms) > 0 && !previousTerm {
+		terms = terms[:len(terms)-1]
+	}
+	return strings.Join(terms, " ")
+}
+
+func quoteTS(text string) string {
+	text = strings.ReplaceAll(text, `\`, `\\`)
+	return `'` + strings.ReplaceAll(text, `'`, `''`) + `'`
+}
+
+// GetRecentArticles queries recently inserted articles of the topic from the db.
+// The articles of all the topics are queried when the topic is empty.
+func (d *PostgresDB) GetRecentArticles(ctx context.Context, topic string) ([]*Article, error) {
+	articles := []*Article{}
+	sql := `
+		SELECT *
+		FROM article
+		WHERE published_at >= NOW() - make_interval(days => $1) AND ($2::text = '' OR topic = $2)
+		ORDER BY published_at DESC
+		LIMIT 10;`
+
+	if err := d.db.SelectContext(ctx, &articles, sql, RecentArticleThreshold, topic); err != nil {
+		return nil, fmt.Errorf("could not fetch recent articles: %w", err)
+	}
+
+	return articles, nil
+}
+
+// GetLatestArticles queries the most recently published articles matching the query.
+func (d *PostgresDB) GetLatestArticles(ctx context.Context, query ArticleQuery, limit int) ([]*Article, error) {
+	query.Sort = SortDate
+	articles, _, err := d.searchArticles(ctx, query, limit)
+	return articles, err
+}
+
+// GetArticleSources returns the names of all the article sources.
+func (d *PostgresDB) GetArticleSources(ctx context.Context) ([]string, error) {
+	sources := []string{}
+	sql := `
+		SELECT DISTINCT source
+		FROM article
+		ORDER BY source;`
+
+	if err := d.db.SelectContext(ctx, &sources, sql); err != nil {
+		return nil, fmt.Errorf("could not fetch article sources: %w", err)
+	}
+
+	return sources, nil
+}
+
+// CountArticles counts the articles published since the given time.
+func (d *PostgresDB) CountArticles(ctx context.Context, since time.Time) (int, error) {
+	count := 0
+	sql := `
+		SELECT COUNT(*)
+		FROM article
+		WHERE published_at >= $1;`
+
+	if err := d.db.GetContext(ctx, &count, sql, pgTime(since)); err != nil {
+		return 0, fmt.Errorf("could not count articles: %w", err)
+	}
+
+	return count, nil
+}
+
+// InsertArticle adds a new article and returns the id.
+func (d *PostgresDB) InsertArticle(ctx context.Context, article *Article) (int, error) {
+	setArticleDefaults(article)
+
+	sql := `
+		INSERT INTO article (
+			"url", "canonical_url", "topic", "title", "description", "source",
+			"author", "lede_img", "published_at", "created_at"
+		)
+		VALUES (
+			:url, :canonical_url, :topic, :title, :description, :source,
+			:author, :lede_img, :published_at, :created_at
+		)
+		RETURNING id;`
+
+	id, err := d.insertReturningID(ctx, sql, article)
+	if err != nil {
+		return 0, fmt.Errorf("could not insert article %v: %w", article.URL, err)
+	}
+	if err := assignStory(ctx, d.db, id, pgTime); err != nil {
+		return 0, fmt.Errorf("could not insert article %v: %w", article.URL, err)
+	}
+	return id, nil
+}
+
+// InsertArticles saves the articles in a single transaction. New articles are
+// inserted, and existing articles with the same url have their metadata updated.
+func (d *PostgresDB) InsertArticles(ctx context.Context, articles []*Article) (*InsertResult, error) {
+	return upsertArticles(ctx, d.db, upsertArticleSQL("IS DISTINCT FROM"), articles, pgArticleSaved)
+}
+
+// pgArticleSaved assigns the story of a new article.
+func pgArticleSaved(ctx context.Context, tx *sqlx.Tx, id int) error {
+	return assignStory(ctx, tx, id, pgTime)
+}
+
+// insertReturningID runs a named insert, and returns the id of the new row.
+// The postgres driver does not support LastInsertId, so the query must return the id.
+func (d *PostgresDB) insertReturningID(ctx context.Context, sql string, arg interface{}) (int, error) {
+	rows, err := d.db.NamedQueryContext(ctx, sql, arg)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	id := 0
+	if rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+	}
+	return id, rows.Err()
+}
+
+// ------------------------------------------------------------------
+// TaskLog
+// ------------------------------------------------------------------
+
+// InsertTaskLog adds a new tasklog and returns the id.
+func (d *PostgresDB) InsertTaskLog(ctx context.Context, tasklog *TaskLog) (int, error) {
+	sql := `
+		INSERT INTO tasklog (
+			"task", "manual", "status", "started_at", "completed_at",
+			"fetched", "inserted", "duplicates", "updated", "failed",
+			"sources", "error"
+		)
+		VALUES (
+			:task, :manual, :status, :started_at, :completed_at,
+			:fetched, :inserted, :duplicates, :updated, :failed,
+			:sources, :error
+		)
+		RETURNING id;`
+
+	id, err := d.insertReturningID(ctx, sql, tasklog)
+	if err != nil {
+		return 0, fmt.Errorf("could not insert tasklog %v: %w", tasklog.Task, err)
+	}
+	return id, nil
+}
+
+// RecordTask is a convenience method to insert a TaskLog, and set its id.
+// The completion time is set to now, if it is not already set.
+func (d *PostgresDB) RecordTask(ctx context.Context, tasklog *TaskLog) error {
+	setTaskLogDefaults(tasklog)
+	id, err := d.InsertTaskLog(ctx, tasklog)
+	tasklog.ID = id
+	return err
+}
+
+// GetRecentTaskLog returns the most recent run of the task which was not a failure, or skipped.
+// An empty TaskLog is returned if the task has never run.
+func (d *PostgresDB) GetRecentTaskLog(ctx context.Context, task string) (*TaskLog, error) {
+	tasklog := &TaskLog{}
+	query := `
+		SELECT * FROM tasklog
+		WHERE task = $1 AND status NOT IN ('failed', 'skipped')
+		ORDER BY completed_at DESC
+		LIMIT 1;`
+
+	err := d.db.GetContext(ctx, tasklog, query, task)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("could not fetch recent tasklog: %w", err)
+	}
+
+	return tasklog, nil
+}
+
+// GetTaskLog returns the tasklog with the id, or ErrTaskLogNotFound.
+func (d *PostgresDB) GetTaskLog(ctx context.Context, id int) (*TaskLog, error) {
+	tasklog := &TaskLog{}
+	err := d.db.GetContext(ctx, tasklog, `SELECT * FROM tasklog WHERE id = $1;`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTaskLogNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch tasklog %v: %w", id, err)
+	}
+	return tasklog, nil
+}
+
+// PruneTaskLog removes the runs which completed before the time, and returns how many
+// were removed. The most recent sync is kept, so that the last sync is still reported.
+func (d *PostgresDB) PruneTaskLog(ctx context.Context, before time.Time) (int, error) {
+	sql := `
+		DELETE FROM tasklog
+		WHERE completed_at < $1
+		AND id NOT IN (
+			SELECT id FROM tasklog
+			WHERE status NOT IN ('failed', 'skipped')
+			ORDER BY completed_at DESC
+			LIMIT 1
+		);`
+
+	result, err := d.db.ExecContext(ctx, sql, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("could not prune the tasklog: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// ------------------------------------------------------------------
+// Backfill
+// ------------------------------------------------------------------
+
+// GetCompletedBackfillWindows returns the backfill windows of the topic which were fetched successfully.
+func (d *PostgresDB) GetCompletedBackfillWindows(ctx context.Context, topic string) ([]*BackfillWindow, error) {
+	windows := []*BackfillWindow{}
+	sql := `
+		SELECT * FROM backfill_window
+		WHERE topic = $1 AND status = 'success'
+		ORDER BY window_from;`
+
+	if err := d.db.SelectContext(ctx, &windows, sql, topic); err != nil {
+		return nil, fmt.Errorf("could not fetch backfill windows: %w", err)
+	}
+	return windows, nil
+}
+
+// InsertBackfillWindow records the outcome of a backfill window and returns the id.
+func (d *PostgresDB) InsertBackfillWindow(ctx context.Context, window *BackfillWindow) (int, error) {
+	sql := `
+		INSERT INTO backfill_window (
+			"topic", "window_from", "window_to", "status", "fetched",
+			"inserted", "updated", "failed", "error", "completed_at"
+		)
+		VALUES (
+			:topic, :window_from, :window_to, :status, :fetched,
+			:inserted, :updated, :failed, :error, :completed_at
+		)
+		RETURNING id;`
+
+	id, err := d.insertReturningID(ctx, sql, window)
+	if err != nil {
+		return 0, fmt.Errorf("could not insert backfill window %v: %w", window, err)
+	}
+	window.ID = id
+	return id, nil
+}
+
+// ------------------------------------------------------------------
+// TaskLease
+// ------------------------------------------------------------------
+
+// AcquireLease takes the lease of the task, if it is free or expired.
+// The lease which holds the task is returned, which is the given lease if it was acquired.
+func (d *PostgresDB) AcquireLease(ctx context.Context, lease *TaskLease) (*TaskLease, error) {
+	sql := `
+		INSERT INTO task_lease (task, holder, acquired_at, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (task) DO UPDATE SET
+			holder = excluded.holder,
+			acquired_at = excluded.acquired_at,
+			expires_at = excluded.expires_at
+		WHERE task_lease.expires_at <= excluded.acquired_at;`
+
+	result, err := d.db.ExecContext(ctx, sql, lease.Task, lease.Holder, lease.AcquiredAt, lease.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("could not acquire lease %v: %w", lease.Task, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 1 {
+		return lease, nil
+	}
+
+	current := &TaskLease{}
+	if err := d.db.GetContext(ctx, current, `SELECT * FROM task_lease WHERE task = $1;`, lease.Task); err != nil {
+		return nil, fmt.Errorf("could not fetch lease %v: %w", lease.Task, err)
+	}
+	return current, nil
+}
+
+// RenewLease extends the lease to its expiry time. An error wrapping ErrTaskLocked
+// is returned when the lease no longer holds the task.
+func (d *PostgresDB) RenewLease(ctx context.Context, lease *TaskLease) error {
+	sql := `UPDATE task_lease SET expires_at = $1 WHERE task = $2 AND holder = $3;`
+	result, err := d.db.ExecContext(ctx, sql, lease.ExpiresAt, lease.Task, lease.Holder)
+	if err != nil {
+		return fmt.Errorf("could not renew lease %v: %w", lease.Task, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %v is no longer held by %v", ErrTaskLocked, lease.Task, lease.Holder)
+	}
+	return nil
+}
+
+// ReleaseLease frees the task, if the lease still holds it.
+func (d *PostgresDB) ReleaseLease(ctx context.Context, lease *TaskLease) error {
+	sql := `DELETE FROM task_lease WHERE task = $1 AND holder = $2;`
+	if _, err := d.db.ExecContext(ctx, sql, lease.Task, lease.Holder); err != nil {
+		return fmt.Errorf("could not release lease %v: %w", lease.Task, err)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------
+// User
+// ------------------------------------------------------------------
+
+// GetUser returns the admin user with the username, or ErrUserNotFound.
+func (d *PostgresDB) GetUser(ctx context.Context, username string) (*User, error) {
+	user := &User{}
+	err := d.db.GetContext(ctx, user, `SELECT * FROM admin_user WHERE username = $1;`, username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch user %v: %w", username, err)
+	}
+	return user, nil
+}
+
+// InsertUser adds a new admin user and returns the id.
+func (d *PostgresDB) InsertUser(ctx context.Context, user *User) (int, error) {
+	sql := `
+		INSERT INTO admin_user ("username", "password_hash", "created_at")
+		VALUES (:username, :password_hash, :created_at)
+		RETURNING id;`
+
+	id, err := d.insertReturningID(ctx, sql, user)
+	if err != nil {
+		return 0, fmt.Errorf("could not insert user %v: %w", user.Username, err)
+	}
+	user.ID = id
+	return id, nil
+}
+
+// UpdateUserPassword changes the password hash of the user, and revokes the sessions
+// of the user. ErrUserNotFound is returned when there is no user with the username.
+func (d *PostgresDB) UpdateUserPassword(ctx context.Context, username, passwordHash string) error {
+	sql := `
+		UPDATE admin_user SET password_hash = $1, session_version = session_version + 1
+		WHERE username = $2;`
+	result, err := d.db.ExecContext(ctx, sql, passwordHash, username)
+	if err != nil {
+		return fmt.Errorf("could not update user %v: %w", username, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// RevokeSessions ends the sessions of the user, on every device.
+// ErrUserNotFound is returned when there is no user with the username.
+func (d *PostgresDB) RevokeSessions(ctx context.Context, username string) error {
+	sql := `UPDATE admin_user SET session_version = session_version + 1 WHERE username = $1;`
+	result, err := d.db.ExecContext(ctx, sql, username)
+	if err != nil {
+		return fmt.Errorf("could not revoke the sessions of user %v: %w", username, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// RevokeSession ends a single session, until it expires. The revoked sessions
+// which have expired are removed.
+func (d *PostgresDB) RevokeSession(ctx context.Context, id string, expiresAt time.Time) error {
+	if _, err := d.db.ExecContext(ctx, `DELETE FROM admin_session_revoked WHERE expires_at < $1;`, time.Now().UTC()); err != nil {
+		return fmt.Errorf("could not remove the expired sessions: %w", err)
+	}
+
+	sql := `
+		INSERT INTO admin_session_revoked (id, expires_at) VALUES ($1, $2)
+		ON CONFLICT (id) DO NOTHING;`
+	if _, err := d.db.ExecContext(ctx, sql, id, expiresAt.UTC()); err != nil {
+		return fmt.Errorf("could not revoke the session: %w", err)
+	}
+	return nil
+}
+
+// IsSessionRevoked reports whether the session was revoked by RevokeSession.
+func (d *PostgresDB) IsSessionRevoked(ctx context.Context, id string) (bool, error) {
+	revoked := false
+	sql := `SELECT EXISTS (SELECT 1 FROM admin_session_revoked WHERE id = $1);`
+	if err := d.db.GetContext(ctx, &revoked, sql, id); err != nil {
+		return false, fmt.Errorf("could not check the session: %w", err)
+	}
+	return revoked, nil
+}
@@ -0,0 +1,181 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+// Test Helpers
+
+// newTestDB creates an in-memory *ServerDB. A single connection is used,
+// because each connection to ':memory:' opens a separate database.
+func newTestDB(t *testing.T) *ServerDB {
+	db := sqlx.MustOpen("sqlite3", ":memory:")
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return &ServerDB{db: db}
+}
+
+// getArticles fetches a page of articles, and fails the test on an error.
+func getArticles(t *testing.T, db Database, query ArticleQuery) *ArticlePage {
+	t.Helper()
+	page, err := db.GetArticles(context.Background(), query)
+	if err != nil {
+		t.Fatalf("could not fetch articles: %v", err)
+	}
+	return page
+}
+
+// ------------------------------------------------------------------
+
+func TestRecordTask(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+
+	started := time.Date(2020, 6, 25, 0, 0, 0, 0, time.UTC)
+	tasklog := &TaskLog{
+		Task:        TaskUpdateArticles,
+		Status:      TaskStatusPartial,
+		StartedAt:   started,
+		CompletedAt: started.Add(time.Minute),
+		Fetched:     10,
+		Inserted:    7,
+		Duplicates:  3,
+		Error:       "newsapi: boom",
+	}
+	tasklog.SetSourceStats([]TaskSourceStat{
+		{Source: "newsapi", Error: "boom"},
+		{Source: "feeds", Fetched: 10},
+	})
+	ctx := context.Background()
+	is.NoErr(db.RecordTask(ctx, tasklog))
+
+	// Failed runs are recorded, but are not considered the most recent sync.
+	is.NoErr(db.RecordTask(ctx, &TaskLog{Task: TaskUpdateArticles, Status: TaskStatusFailed}))
+
+	recent, err := db.GetRecentTaskLog(ctx, TaskUpdateArticles)
+	is.NoErr(err)
+	is.Equal(recent.Status, TaskStatusPartial)
+	is.Equal(recent.Duration(), time.Minute)
+	is.Equal(recent.Inserted, 7)
+	is.Equal(recent.Duplicates, 3)
+	is.Equal(recent.Error, "newsapi: boom")
+	is.Equal(len(recent.SourceStats()), 2)
+	is.Equal(recent.SourceStats()[1].Fetched, 10)
+}
+
+func TestGetArticles_Cancelled(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := db.GetArticles(ctx, ArticleQuery{})
+	is.True(errors.Is(err, context.Canceled)) // Queries are cancelled with the context
+}
+
+func TestInsertArticles_Failed(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+	db.db.MustExec(`
+		CREATE TRIGGER reject_article BEFORE INSERT ON article WHEN NEW.title = 'Rejected' BEGIN
+			SELECT RAISE(ABORT, 'rejected');
+		END;`)
+
+	published := time.Date(2020, 6, 25, 0, 0, 0, 0, time.UTC)
+	saved, err := db.InsertArticles(context.Background(), []*Article{
+		{URL: "https://example.com/1", Title: "Rejected", Source: "cnn", PublishedAt: published, CreatedAt: published},
+		{URL: "https://example.com/2", Title: "Saved", Source: "cnn", PublishedAt: published, CreatedAt: published},
+	})
+	is.NoErr(err)
+	is.Equal(saved.Inserted(), 1) // The rest of the batch is saved
+	is.Equal(saved.Failed(), 1)
+	is.True(strings.Contains(saved.Errors[0].Error(), "https://example.com/1"))
+
+	page := getArticles(t, db, ArticleQuery{})
+	is.Equal(len(page.Articles), 1)
+	is.Equal(page.Articles[0].Title, "Saved")
+}
+
+func TestInsertArticles_Cancelled(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	published := time.Date(2020, 6, 25, 0, 0, 0, 0, time.UTC)
+	_, err := db.InsertArticles(ctx, []*Article{
+		{URL: "https://example.com/1", Title: "One", Source: "cnn", PublishedAt: published, CreatedAt: published},
+	})
+	is.True(errors.Is(err, context.Canceled))
+
+	page := getArticles(t, db, ArticleQuery{})
+	is.Equal(len(page.Articles), 0) // Nothing is saved
+}
+
+func TestParseSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		dsn    string
+		path   string
+		params string
+	}{
+		{"", "file:" + DefaultDSN, ""},
+		{"/var/lib/dacabot.sqlite", "file:/var/lib/dacabot.sqlite", ""},
+		{"sqlite:///var/lib/dacabot.sqlite", "file:/var/lib/dacabot.sqlite", ""},
+		{"sqlite:dacabot.sqlite", "file:dacabot.sqlite", ""},
+		{"file:dacabot.sqlite?_busy_timeout=100", "file:dacabot.sqlite", "_busy_timeout=100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dsn, func(t *testing.T) {
+			is := is.New(t)
+
+			dsn, err := parseSQLiteDSN(tt.dsn)
+			is.NoErr(err)
+			is.True(strings.HasPrefix(dsn, tt.path+"?"))
+			is.True(strings.Contains(dsn, "_journal_mode=WAL")) // Pragmas are set
+			is.True(strings.Contains(dsn, "_foreign_keys=on"))
+			if tt.params != "" {
+				is.True(strings.Contains(dsn, tt.params)) // DSN pragmas take precedence
+			}
+		})
+	}
+
+	_, err := parseSQLiteDSN("mysql://localhost/dacabot")
+	is.New(t).True(err != nil) // Unsupported scheme
+}
+
+func TestNewDB_Pragmas(t *testing.T) {
+	is := is.New(t)
+
+	dsn := "sqlite://" + filepath.Join(t.TempDir(), "dacabot.sqlite")
+	db, err := NewDB(dsn)
+	is.NoErr(err)
+	defer db.Close()
+
+	serverDB := db.(*ServerDB)
+	journalMode, busyTimeout := "", 0
+	is.NoErr(serverDB.db.Get(&journalMode, "PRAGMA journal_mode;"))
+	is.NoErr(serverDB.db.Get(&busyTimeout, "PRAGMA busy_timeout;"))
+
+	is.Equal(journalMode, "wal")
+	is.Equal(busyTimeout, 5000)
+}
@@ -0,0 +1,311 @@
+package app
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// NewFeedClient creates a new client which polls RSS and Atom feeds.
+// The feed urls are read from the comma-separated FEED_URLS env var.
+func NewFeedClient() *FeedClient {
+	urls := []string{}
+	for _, u := range strings.Split(os.Getenv("FEED_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return &FeedClient{
+		URLs:       urls,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// FeedClient is a Source which reads articles from RSS 2.0 and Atom feeds.
+type FeedClient struct {
+	URLs       []string
+	HTTPClient *http.Client
+}
+
+// Name of the source.
+func (f *FeedClient) Name() string {
+	return "feeds"
+}
+
+// GetArticles fetches each feed, and returns the items which mention
+// the search term and were published within the date range.
+func (f *FeedClient) GetArticles(ctx context.Context, q string, from, to time.Time) ([]*Article, error) {
+	articles := []*Article{}
+	failed := []string{}
+
+	for _, feedURL := range f.URLs {
+		if ctx.Err() != nil {
+			return articles, ctx.Err()
+		}
+
+		items, err := f.getFeed(ctx, feedURL)
+		if err != nil {
+			fmt.Printf("[feeds] Error fetching feed %v | %v\n", feedURL, err)
+			failed = append(failed, feedURL)
+			continue
+		}
+
+		for _, article := range items {
+			if matchesFeedQuery(article, q) && publishedWithin(article, from, to) {
+				articles = append(articles, article)
+			}
+		}
+	}
+
+	// Only fail the source when none of the feeds could be read.
+	if len(f.URLs) > 0 && len(failed) == len(f.URLs) {
+		return nil, fmt.Errorf("could not fetch any feeds: %v", strings.Join(failed, ", "))
+	}
+
+	return articles, nil
+}
+
+// getFeed fetches and parses a single feed.
+func (f *FeedClient) getFeed(ctx context.Context, feedURL string) ([]*Article, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := f.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got status code %v", res.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFeed(data)
+}
+
+// matchesFeedQuery reports whether the article title or description mentions the search term.
+// Like NewsAPI, the search term can list alternatives with 'OR', ex: `"TPS" OR "temporary protected status"`.
+func matchesFeedQuery(article *Article, q string) bool {
+	title := strings.ToLower(article.Title)
+	description := strings.ToLower(article.Description)
+
+	for _, term := range strings.Split(q, " OR ") {
+		term = strings.ToLower(strings.Trim(strings.TrimSpace(term), `"`))
+		if term != "" && (strings.Contains(title, term) || strings.Contains(description, term)) {
+			return true
+		}
+	}
+	return false
+}
+
+// publishedWithin reports whether the article was published between the
+// start of the `from` day and the end of the `to` day.
+func publishedWithin(article *Article, from, to time.Time) bool {
+	return !article.PublishedAt.Before(from) && article.PublishedAt.Before(to.AddDate(0, 0, 1))
+}
+
+// ------------------------------------------------------------------
+// Feed parsing
+// ------------------------------------------------------------------
+
+// parseFeed detects the feed format, and converts the feed items into articles.
+func parseFeed(data []byte) ([]*Article, error) {
+	root := struct {
+		XMLName xml.Name
+	}{}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	switch root.XMLName.Local {
+	case "rss":
+		feed := rssFeed{}
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return nil, err
+		}
+		return feed.transform(), nil
+	case "feed":
+		feed := atomFeed{}
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return nil, err
+		}
+		return feed.transform(), nil
+	}
+
+	return nil, fmt.Errorf("unknown feed format <%v>", root.XMLName.Local)
+}
+
+// mediaXML is a Media RSS element, used by feeds for lede images.
+type mediaXML struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+func (m mediaXML) isImage() bool {
+	return m.URL != "" && (m.Medium == "image" || strings.HasPrefix(m.Type, "image/") || (m.Medium == "" && m.Type == ""))
+}
+
+// rssFeed is the format of an RSS 2.0 feed.
+type rssFeed struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssItem struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	Description string     `xml:"description"`
+	Author      string     `xml:"author"`
+	Creator     string     `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	PubDate     string     `xml:"pubDate"`
+	Enclosures  []mediaXML `xml:"enclosure"`
+	Media       []mediaXML `xml:"http://search.yahoo.com/mrss/ content"`
+	Thumbnails  []mediaXML `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+// Transform converts feed items to objects of type *Article.
+func (f rssFeed) transform() []*Article {
+	articles := []*Article{}
+
+	for _, item := range f.Channel.Items {
+		author := item.Author
+		if author == "" {
+			author = item.Creator
+		}
+
+		article := &Article{
+			URL:         strings.TrimSpace(item.Link),
+			Title:       strings.TrimSpace(item.Title),
+			Description: strings.TrimSpace(item.Description),
+			Source:      strings.TrimSpace(f.Channel.Title),
+			Author:      strings.TrimSpace(author),
+			LedeImg:     firstImage(item.Enclosures, item.Media, item.Thumbnails),
+			PublishedAt: parseFeedDate(item.PubDate),
+			CreatedAt:   time.Now().UTC(),
+		}
+		if article.URL != "" && article.Title != "" {
+			articles = append(articles, article)
+		}
+	}
+
+	return articles
+}
+
+// atomFeed is the format of an Atom feed.
+type atomFeed struct {
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title      string     `xml:"title"`
+	Links      []atomLink `xml:"link"`
+	Summary    string     `xml:"summary"`
+	Content    string     `xml:"content"`
+	Authors    []string   `xml:"author>name"`
+	Published  string     `xml:"published"`
+	Updated    string     `xml:"updated"`
+	Media      []mediaXML `xml:"http://search.yahoo.com/mrss/ content"`
+	Thumbnails []mediaXML `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// Transform converts feed entries to objects of type *Article.
+func (f atomFeed) transform() []*Article {
+	articles := []*Article{}
+
+	for _, entry := range f.Entries {
+		link := ""
+		enclosures := []mediaXML{}
+		for _, l := range entry.Links {
+			switch l.Rel {
+			case "", "alternate":
+				if link == "" {
+					link = l.Href
+				}
+			case "enclosure":
+				enclosures = append(enclosures, mediaXML{URL: l.Href, Type: l.Type})
+			}
+		}
+
+		description := entry.Summary
+		if description == "" {
+			description = entry.Content
+		}
+
+		published := entry.Published
+		if published == "" {
+			published = entry.Updated
+		}
+
+		article := &Article{
+			URL:         strings.TrimSpace(link),
+			Title:       strings.TrimSpace(entry.Title),
+			Description: strings.TrimSpace(description),
+			Source:      strings.TrimSpace(f.Title),
+			Author:      strings.TrimSpace(strings.Join(entry.Authors, ", ")),
+			LedeImg:     firstImage(enclosures, entry.Media, entry.Thumbnails),
+			PublishedAt: parseFeedDate(published),
+			CreatedAt:   time.Now().UTC(),
+		}
+		if article.URL != "" && article.Title != "" {
+			articles = append(articles, article)
+		}
+	}
+
+	return articles
+}
+
+// firstImage returns the url of the first image found in the given media elements.
+func firstImage(media ...[]mediaXML) string {
+	for _, elements := range media {
+		for _, m := range elements {
+			if m.isImage() {
+				return m.URL
+			}
+		}
+	}
+	return ""
+}
+
+// feedDateLayouts are the date formats commonly found in the wild.
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseFeedDate parses a feed date. The zero time is returned if the date cannot be parsed.
+func parseFeedDate(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date.UTC()
+		}
+	}
+	return time.Time{}
+}
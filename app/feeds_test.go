@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestFeedClient(t *testing.T) {
+	is := is.New(t)
+
+	// The test binary runs from the project root (see server_test.go).
+	ts := httptest.NewServer(http.FileServer(http.Dir("app/testdata")))
+	defer ts.Close()
+
+	client := &FeedClient{
+		URLs:       []string{ts.URL + "/feed.rss", ts.URL + "/feed.atom", ts.URL + "/missing.rss"},
+		HTTPClient: ts.Client(),
+	}
+
+	from := MustParseDate("2020-06-25")
+	to := MustParseDate("2020-06-27")
+	articles, err := client.GetArticles(context.Background(), "daca", from, to)
+
+	is.NoErr(err)              // A missing feed does not fail the source
+	is.Equal(len(articles), 3) // Articles are filtered by search term and date
+
+	rss := articles[0]
+	is.Equal(rss.Title, "DACA recipients rally at city hall")
+	is.Equal(rss.URL, "https://local-paper.example.com/daca-rally")
+	is.Equal(rss.Source, "The Local Paper")
+	is.Equal(rss.Author, "Jane Reporter")
+	is.Equal(rss.LedeImg, "https://local-paper.example.com/rally.jpg")
+	is.Equal(rss.PublishedAt, time.Date(2020, 6, 27, 14, 30, 0, 0, time.UTC))
+
+	is.Equal(articles[1].LedeImg, "https://local-paper.example.com/budget.jpg") // Media RSS image
+
+	atom := articles[2]
+	is.Equal(atom.URL, "https://news.university.example.edu/daca-support")
+	is.Equal(atom.Source, "University News Office")
+	is.Equal(atom.Author, "News Office")
+	is.Equal(atom.Description, "New scholarships and legal services are available.")
+	is.Equal(atom.LedeImg, "https://news.university.example.edu/support.png")
+	is.Equal(atom.PublishedAt, time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC))
+}
+
+func TestFeedClient_AllFeedsFail(t *testing.T) {
+	is := is.New(t)
+
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	client := &FeedClient{URLs: []string{ts.URL + "/feed.rss"}, HTTPClient: ts.Client()}
+	_, err := client.GetArticles(context.Background(), "daca", time.Now(), time.Now())
+
+	is.True(err != nil) // Source fails when no feed can be read
+}
+
+func TestMatchesFeedQuery(t *testing.T) {
+	is := is.New(t)
+
+	article := &Article{Title: "Court extends Temporary Protected Status", Description: "Haitians may stay."}
+
+	is.True(matchesFeedQuery(article, "temporary protected status"))
+	is.True(matchesFeedQuery(article, `"TPS" OR "temporary protected status"`)) // Any of the alternatives
+	is.True(matchesFeedQuery(article, "haitians"))                              // The description is searched
+	is.True(!matchesFeedQuery(article, `"DACA" OR "Dreamers"`))
+}
@@ -0,0 +1,219 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Names of the registered jobs.
+const (
+	// JobFetchArticles fetches the articles of every topic.
+	JobFetchArticles = "fetch-articles"
+
+	// JobPruneTaskLog removes the old runs from the task log.
+	JobPruneTaskLog = "prune-tasklog"
+)
+
+// Job is a task which runs on a schedule.
+type Job struct {
+	// Name identifies the job in the config file and in logs, ex: "fetch-articles".
+	Name string
+
+	// Description is shown by 'jobs list' and the jobs page.
+	Description string
+
+	// Schedule is a cron expression, ex: "0 */6 * * *" or "@midnight".
+	Schedule string
+
+	// Timeout cancels the run after the duration. Zero means no timeout.
+	Timeout time.Duration
+
+	// LookbackDays is the number of days before the run that the job covers.
+	// The prune-tasklog job keeps the runs of these days.
+	LookbackDays int
+
+	// Run performs the job over the date range.
+	Run func(ctx context.Context, db Database, from, to time.Time) error
+}
+
+// jobRegistry holds the registered jobs.
+var jobRegistry = []*Job{
+	{
+		Name:         JobFetchArticles,
+		Description:  "Fetch the recent articles of every topic",
+		Schedule:     "@midnight",
+		Timeout:      30 * time.Minute,
+		LookbackDays: 3,
+		Run:          fetchAllTopics,
+	},
+	{
+		Name:         JobPruneTaskLog,
+		Description:  "Remove the task log runs older than the lookback days",
+		Schedule:     "@weekly",
+		Timeout:      5 * time.Minute,
+		LookbackDays: 90,
+		Run:          pruneTaskLog,
+	},
+}
+
+// RegisterJob adds a job to the registry.
+func RegisterJob(job *Job) {
+	jobRegistry = append(jobRegistry, job)
+}
+
+// GetJobs returns the registered jobs.
+func GetJobs() []*Job {
+	return jobRegistry
+}
+
+// GetJob returns the registered job with the name.
+func GetJob(name string) (*Job, bool) {
+	for _, job := range jobRegistry {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return nil, false
+}
+
+// jobNames lists the names of the registered jobs, for error messages.
+func jobNames() string {
+	names := []string{}
+	for _, job := range jobRegistry {
+		names = append(names, job.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// NextRun returns the next time the job is scheduled after the given time.
+func (j *Job) NextRun(after time.Time) (time.Time, error) {
+	schedule, err := cron.ParseStandard(j.Schedule)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid schedule of job %v: %w", j.Name, err)
+	}
+	return schedule.Next(after), nil
+}
+
+// Execute runs the job once, over the lookback days before now.
+// The run is cancelled after the timeout of the job.
+func (j *Job) Execute(ctx context.Context, db Database) error {
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.Timeout)
+		defer cancel()
+	}
+
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -j.LookbackDays)
+	return j.Run(ctx, db, from, to)
+}
+
+// fetchAllTopics fetches the articles of every topic. A failing topic does
+// not stop the other topics from being fetched. Topics which are being
+// fetched by another run are skipped.
+func fetchAllTopics(ctx context.Context, db Database, from, to time.Time) error {
+	failed := []string{}
+	for _, topic := range GetTopics() {
+		_, err := UpdateArticles(ctx, db, topic, from, to, false)
+		if errors.Is(err, ErrTaskLocked) {
+			continue
+		}
+		if err != nil {
+			fmt.Printf("[job] %v %v failed: %v\n", JobFetchArticles, topic.Slug, err)
+			failed = append(failed, topic.Slug)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not fetch the articles of %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// pruneTaskLog removes the task log runs which completed before the date range.
+func pruneTaskLog(ctx context.Context, db Database, from, to time.Time) error {
+	pruned, err := db.PruneTaskLog(ctx, from)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[job] %v removed %v runs\n", JobPruneTaskLog, pruned)
+	return nil
+}
+
+// Scheduler runs the registered jobs on their schedules.
+type Scheduler struct {
+	cron    *cron.Cron
+	entries map[string]cron.EntryID
+}
+
+// JobStatus is a job, and its previous and next scheduled runs.
+// The previous run is zero until the job has run since the server started.
+type JobStatus struct {
+	Job     *Job
+	NextRun time.Time
+	PrevRun time.Time
+}
+
+// SetupTasks schedules the registered jobs, and starts running them.
+// A failing job is logged, and does not stop the server.
+// Ref: https://godoc.org/github.com/robfig/cron
+// CRON Ref: https://www.adminschoice.com/crontab-quick-reference
+func SetupTasks(db Database) (*Scheduler, error) {
+	fmt.Println("[setup] tasks")
+	s := &Scheduler{
+		cron:    cron.New(cron.WithChain(cron.Recover(cron.DefaultLogger))),
+		entries: map[string]cron.EntryID{},
+	}
+
+	for _, job := range GetJobs() {
+		job := job
+		id, err := s.cron.AddFunc(job.Schedule, func() {
+			if err := job.Execute(context.Background(), db); err != nil {
+				fmt.Printf("[job] %v failed: %v\n", job.Name, err)
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not schedule job %v: %w", job.Name, err)
+		}
+		s.entries[job.Name] = id
+	}
+
+	s.cron.Start()
+	return s, nil
+}
+
+// Stop stops scheduling the jobs. Running jobs are not interrupted.
+func (s *Scheduler) Stop() {
+	s.cron.Stop()
+}
+
+// Jobs returns the status of the registered jobs, outside of a scheduler,
+// such as in 'jobs list'. The next runs are computed from the schedules.
+func Jobs() []JobStatus {
+	now := time.Now()
+	statuses := []JobStatus{}
+	for _, job := range GetJobs() {
+		status := JobStatus{Job: job}
+		if next, err := job.NextRun(now); err == nil {
+			status.NextRun = next
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Jobs returns the status of the registered jobs, with the runs of their cron entries.
+func (s *Scheduler) Jobs() []JobStatus {
+	statuses := Jobs()
+	for i, status := range statuses {
+		if id, ok := s.entries[status.Job.Name]; ok {
+			entry := s.cron.Entry(id)
+			statuses[i].NextRun, statuses[i].PrevRun = entry.Next, entry.Prev
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+// Test Helpers
+
+// setTestJobs registers the jobs for the test, and restores the jobs after the test.
+func setTestJobs(t *testing.T, jobs ...*Job) {
+	previous := jobRegistry
+	jobRegistry = jobs
+	t.Cleanup(func() { jobRegistry = previous })
+}
+
+// ------------------------------------------------------------------
+
+func TestGetJob(t *testing.T) {
+	is := is.New(t)
+	setTestJobs(t, jobRegistry...)
+
+	job, ok := GetJob(JobFetchArticles)
+	is.True(ok)
+	is.Equal(job.Schedule, "@midnight")
+
+	RegisterJob(&Job{Name: "prune", Schedule: "@weekly"})
+	_, ok = GetJob("prune")
+	is.True(ok) // Registered jobs are found
+	_, ok = GetJob("digest")
+	is.True(!ok)
+}
+
+func TestJob_NextRun(t *testing.T) {
+	is := is.New(t)
+	after := time.Date(2020, 8, 10, 15, 30, 0, 0, time.UTC)
+
+	next, err := (&Job{Schedule: "@midnight"}).NextRun(after)
+	is.NoErr(err)
+	is.Equal(next, time.Date(2020, 8, 11, 0, 0, 0, 0, time.UTC))
+
+	next, err = (&Job{Schedule: "0 */6 * * *"}).NextRun(after)
+	is.NoErr(err)
+	is.Equal(next, time.Date(2020, 8, 10, 18, 0, 0, 0, time.UTC))
+
+	_, err = (&Job{Schedule: "daily"}).NextRun(after)
+	is.True(err != nil) // Invalid schedule
+}
+
+func TestJob_Execute(t *testing.T) {
+	is := is.New(t)
+
+	var from, to time.Time
+	job := &Job{
+		Name:         "test",
+		Timeout:      10 * time.Millisecond,
+		LookbackDays: 7,
+		Run: func(ctx context.Context, db Database, f, t time.Time) error {
+			from, to = f, t
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}
+
+	err := job.Execute(context.Background(), &MockServerDB{})
+	is.True(errors.Is(err, context.DeadlineExceeded)) // The run is cancelled after the timeout
+	is.Equal(to.Sub(from), 7*24*time.Hour)            // The run covers the lookback days
+}
+
+func TestPruneTaskLog(t *testing.T) {
+	is := is.New(t)
+
+	var before time.Time
+	db := &MockServerDB{
+		pruneTaskLogMock: func(ctx context.Context, b time.Time) (int, error) {
+			before = b
+			return 3, nil
+		},
+	}
+
+	job, ok := GetJob(JobPruneTaskLog)
+	is.True(ok)
+	is.NoErr(job.Execute(context.Background(), db))
+	is.True(time.Since(before) > 89*24*time.Hour) // The runs of the lookback days are kept
+}
+
+func TestSetupTasks(t *testing.T) {
+	is := is.New(t)
+	setTestJobs(t,
+		&Job{Name: "hourly", Schedule: "@hourly", Run: func(context.Context, Database, time.Time, time.Time) error { return nil }},
+		&Job{Name: "daily", Schedule: "@midnight", Run: func(context.Context, Database, time.Time, time.Time) error { return nil }},
+	)
+
+	scheduler, err := SetupTasks(&MockServerDB{})
+	is.NoErr(err)
+	defer scheduler.Stop()
+
+	statuses := scheduler.Jobs()
+	is.Equal(len(statuses), 2)
+	is.Equal(statuses[0].Job.Name, "hourly")
+	is.True(statuses[0].NextRun.After(time.Now()))                 // Next run comes from the cron entry
+	is.True(statuses[0].NextRun.Before(time.Now().Add(time.Hour))) // Hourly
+	is.True(statuses[0].PrevRun.IsZero())                          // The job has not run yet
+
+	setTestJobs(t, &Job{Name: "invalid", Schedule: "daily"})
+	_, err = SetupTasks(&MockServerDB{})
+	is.True(err != nil) // Invalid schedules are not scheduled
+}
+
+func TestJobs(t *testing.T) {
+	is := is.New(t)
+	setTestJobs(t, &Job{Name: "daily", Schedule: "@midnight"})
+
+	statuses := Jobs()
+	is.Equal(len(statuses), 1)
+	is.True(statuses[0].NextRun.After(time.Now())) // Next run is computed from the schedule
+	is.True(statuses[0].PrevRun.IsZero())
+}
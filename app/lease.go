@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// TaskLeaseTTL is how long a task is locked by a run. The lease is renewed
+// while the run is in progress, so only a run whose process died loses its
+// lease to the next run.
+const TaskLeaseTTL = 10 * time.Minute
+
+// ErrTaskLocked is returned when a task is already running, in this
+// process or in another one.
+var ErrTaskLocked = errors.New("task is already running")
+
+// TaskLease is a lock on a task, held by a single run until it expires.
+type TaskLease struct {
+	Task       string    `db:"task"`
+	Holder     string    `db:"holder"`
+	AcquiredAt time.Time `db:"acquired_at"`
+	ExpiresAt  time.Time `db:"expires_at"`
+
+	// stop ends the renewal of the lease.
+	stop context.CancelFunc
+}
+
+// newLeaseHolder identifies a run of a task, ex: "web-1:4120:5f2a9c1e".
+// Each run has its own holder, so that two runs in the same process exclude each other.
+func newLeaseHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%v:%v:%08x", host, os.Getpid(), uint32(time.Now().UnixNano()))
+	}
+	return fmt.Sprintf("%v:%v:%v", host, os.Getpid(), hex.EncodeToString(id))
+}
+
+// AcquireTaskLease locks the task until the ttl elapses. A lease which expired is
+// taken over. When the task is locked by another run, an error wrapping ErrTaskLocked
+// is returned. The lease is renewed every third of the ttl until it is released
+// with ReleaseTaskLease.
+func AcquireTaskLease(ctx context.Context, db Database, task string, ttl time.Duration) (*TaskLease, error) {
+	now := time.Now().UTC()
+	lease := &TaskLease{
+		Task:       task,
+		Holder:     newLeaseHolder(),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+
+	current, err := db.AcquireLease(ctx, lease)
+	if err != nil {
+		return nil, err
+	}
+	if current.Holder != lease.Holder {
+		return nil, fmt.Errorf("%w: %v is held by %v until %v", ErrTaskLocked,
+			task, current.Holder, current.ExpiresAt.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	renewCtx, stop := context.WithCancel(context.Background())
+	lease.stop = stop
+	go renewTaskLease(renewCtx, db, *lease, ttl)
+	return lease, nil
+}
+
+// renewTaskLease extends the lease by the ttl, every third of the ttl, until the context is done.
+// The lease is a copy, so that the lease of the run is not modified.
+func renewTaskLease(ctx context.Context, db Database, lease TaskLease, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			lease.ExpiresAt = now.UTC().Add(ttl)
+			if err := db.RenewLease(ctx, &lease); err != nil && ctx.Err() == nil {
+				fmt.Printf("[lease] %v\n", err)
+			}
+		}
+	}
+}
+
+// ReleaseTaskLease unlocks the task. It is released even when the
+// context of the run was cancelled.
+func ReleaseTaskLease(db Database, lease *TaskLease) {
+	if lease.stop != nil {
+		lease.stop()
+	}
+	if err := db.ReleaseLease(context.Background(), lease); err != nil {
+		fmt.Printf("[lease] %v\n", err)
+	}
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+// Test Helpers
+
+// blockingSource returns its articles once it is unblocked.
+type blockingSource struct {
+	started chan bool
+	unblock chan bool
+}
+
+func (b *blockingSource) Name() string {
+	return "blocking"
+}
+
+func (b *blockingSource) GetArticles(ctx context.Context, q string, from, to time.Time) ([]*Article, error) {
+	b.started <- true
+	<-b.unblock
+	return []*Article{{URL: "https://example.com/1", Title: "Article", Source: "example", PublishedAt: from}}, nil
+}
+
+// ------------------------------------------------------------------
+
+func TestAcquireTaskLease_Concurrent(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+
+	const callers = 8
+	var wg sync.WaitGroup
+	leases := make(chan *TaskLease, callers)
+	locked := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lease, err := AcquireTaskLease(context.Background(), db, TaskUpdateArticles, time.Minute)
+			if err != nil {
+				locked <- err
+				return
+			}
+			leases <- lease
+		}()
+	}
+	wg.Wait()
+	close(leases)
+	close(locked)
+
+	is.Equal(len(leases), 1) // A single caller holds the lease
+	for err := range locked {
+		is.True(errors.Is(err, ErrTaskLocked)) // The other callers are locked out
+	}
+
+	ReleaseTaskLease(db, <-leases)
+	lease, err := AcquireTaskLease(context.Background(), db, TaskUpdateArticles, time.Minute)
+	is.NoErr(err) // The released task is acquired again
+	ReleaseTaskLease(db, lease)
+}
+
+func TestAcquireTaskLease_Renewed(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+
+	ctx := context.Background()
+	lease, err := AcquireTaskLease(ctx, db, TaskUpdateArticles, 300*time.Millisecond)
+	is.NoErr(err)
+
+	// The lease is still held after its ttl elapsed, while the run is in progress.
+	time.Sleep(500 * time.Millisecond)
+	_, err = AcquireTaskLease(ctx, db, TaskUpdateArticles, time.Minute)
+	is.True(errors.Is(err, ErrTaskLocked))
+
+	ReleaseTaskLease(db, lease)
+	lease, err = AcquireTaskLease(ctx, db, TaskUpdateArticles, time.Minute)
+	is.NoErr(err) // The released task is acquired again
+	ReleaseTaskLease(db, lease)
+}
+
+func TestNewLeaseHolder(t *testing.T) {
+	is := is.New(t)
+
+	first, second := newLeaseHolder(), newLeaseHolder()
+	is.True(first != second) // Each run has its own holder
+}
+
+func TestUpdateArticles_Overlapping(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+
+	source := &blockingSource{started: make(chan bool), unblock: make(chan bool)}
+	previous := sourceRegistry
+	SetSources(func() Source { return source })
+	t.Cleanup(func() { sourceRegistry = previous })
+
+	ctx := context.Background()
+	to := time.Date(2020, 8, 10, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, -3)
+
+	// The first run holds the lease while it fetches.
+	firstErr := make(chan error)
+	go func() {
+		_, err := UpdateArticles(ctx, db, DefaultTopic, from, to, false)
+		firstErr <- err
+	}()
+	<-source.started
+
+	// The second run is skipped, and recorded.
+	_, err := UpdateArticles(ctx, db, DefaultTopic, from, to, true)
+	is.True(errors.Is(err, ErrTaskLocked))
+
+	skipped := &TaskLog{}
+	is.NoErr(db.db.Get(skipped, `SELECT * FROM tasklog WHERE status = 'skipped';`))
+	is.True(skipped.Manual)
+	is.Equal(skipped.Task, TaskUpdateArticles)
+
+	close(source.unblock)
+	is.NoErr(<-firstErr)
+
+	recent, err := db.GetRecentTaskLog(ctx, TaskUpdateArticles)
+	is.NoErr(err)
+	is.Equal(recent.Status, TaskStatusSuccess) // The skipped run is not the last sync
+	is.Equal(recent.Inserted, 1)
+
+	// The lease is released after the run.
+	go func() { <-source.started }()
+	_, err = UpdateArticles(ctx, db, DefaultTopic, from, to, false)
+	is.NoErr(err)
+}
@@ -0,0 +1,607 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Migration is a versioned change to the database schema.
+// Migrations are applied in order of their version.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is the state of a migration in the database.
+type MigrationStatus struct {
+	Migration
+	AppliedAt time.Time
+}
+
+// Applied reports whether the migration has been applied.
+func (m MigrationStatus) Applied() bool {
+	return !m.AppliedAt.IsZero()
+}
+
+// Migrator is implemented by databases with versioned schema migrations.
+type Migrator interface {
+	MigrateUp() ([]Migration, error)
+	MigrateDown(version int) ([]Migration, error)
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+// Migrations are the schema migrations of the sqlite database.
+// Never edit a migration once it is released, add a new one instead.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create article and tasklog",
+		Up: `
+			CREATE TABLE article (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				url VARCHAR(100) UNIQUE NOT NULL,
+				title VARCHAR(100) NOT NULL,
+				description VARCHAR(100),
+				source VARCHAR(100) NOT NULL,
+				author VARCHAR(100),
+				lede_img VARCHAR(100),
+				published_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL
+			);
+
+			CREATE TABLE tasklog (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				task VARCHAR(100) NOT NULL,
+				manual BOOLEAN DEFAULT FALSE,
+				completed_at DATETIME NOT NULL
+			);`,
+		Down: `
+			DROP TABLE IF EXISTS article_fts;
+			DROP TABLE article;
+			DROP TABLE tasklog;`,
+	},
+	{
+		Version: 2,
+		Name:    "add task run columns to tasklog",
+		Up: `
+			ALTER TABLE tasklog ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'success';
+			ALTER TABLE tasklog ADD COLUMN started_at DATETIME;
+			ALTER TABLE tasklog ADD COLUMN fetched INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE tasklog ADD COLUMN inserted INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE tasklog ADD COLUMN duplicates INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE tasklog ADD COLUMN sources TEXT NOT NULL DEFAULT '[]';
+			ALTER TABLE tasklog ADD COLUMN error TEXT NOT NULL DEFAULT '';
+			UPDATE tasklog SET started_at = completed_at;`,
+		// SQLite cannot drop columns, so the table is rebuilt.
+		Down: `
+			CREATE TABLE tasklog_v1 (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				task VARCHAR(100) NOT NULL,
+				manual BOOLEAN DEFAULT FALSE,
+				completed_at DATETIME NOT NULL
+			);
+			INSERT INTO tasklog_v1 (id, task, manual, completed_at)
+			SELECT id, task, manual, completed_at FROM tasklog;
+			DROP TABLE tasklog;
+			ALTER TABLE tasklog_v1 RENAME TO tasklog;`,
+	},
+	{
+		Version: 3,
+		Name:    "add updated and failed counts to tasklog",
+		Up: `
+			ALTER TABLE tasklog ADD COLUMN updated INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE tasklog ADD COLUMN failed INTEGER NOT NULL DEFAULT 0;`,
+		Down: `
+			CREATE TABLE tasklog_v2 (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				task VARCHAR(100) NOT NULL,
+				manual BOOLEAN DEFAULT FALSE,
+				completed_at DATETIME NOT NULL,
+				status VARCHAR(20) NOT NULL DEFAULT 'success',
+				started_at DATETIME,
+				fetched INTEGER NOT NULL DEFAULT 0,
+				inserted INTEGER NOT NULL DEFAULT 0,
+				duplicates INTEGER NOT NULL DEFAULT 0,
+				sources TEXT NOT NULL DEFAULT '[]',
+				error TEXT NOT NULL DEFAULT ''
+			);
+			INSERT INTO tasklog_v2 (
+				id, task, manual, completed_at, status, started_at,
+				fetched, inserted, duplicates, sources, error
+			)
+			SELECT
+				id, task, manual, completed_at, status, started_at,
+				fetched, inserted, duplicates, sources, error
+			FROM tasklog;
+			DROP TABLE tasklog;
+			ALTER TABLE tasklog_v2 RENAME TO tasklog;`,
+	},
+	{
+		Version: 4,
+		Name:    "add canonical url to article",
+		// The canonical urls are normalized in Go, see backfillCanonicalURLs.
+		Up: `
+			ALTER TABLE article ADD COLUMN canonical_url VARCHAR(100) NOT NULL DEFAULT '';
+			CREATE INDEX article_canonical_url_idx ON article (canonical_url);`,
+		Down: `
+			CREATE TABLE article_v3 (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				url VARCHAR(100) UNIQUE NOT NULL,
+				title VARCHAR(100) NOT NULL,
+				description VARCHAR(100),
+				source VARCHAR(100) NOT NULL,
+				author VARCHAR(100),
+				lede_img VARCHAR(100),
+				published_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL
+			);
+			INSERT INTO article_v3 (
+				id, url, title, description, source, author,
+				lede_img, published_at, created_at
+			)
+			SELECT
+				id, url, title, description, source, author,
+				lede_img, published_at, created_at
+			FROM article;
+			DROP TABLE article;
+			ALTER TABLE article_v3 RENAME TO article;`,
+	},
+	{
+		Version: 5,
+		Name:    "create backfill_window",
+		Up: `
+			CREATE TABLE backfill_window (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				window_from DATETIME NOT NULL,
+				window_to DATETIME NOT NULL,
+				status VARCHAR(20) NOT NULL,
+				fetched INTEGER NOT NULL DEFAULT 0,
+				inserted INTEGER NOT NULL DEFAULT 0,
+				updated INTEGER NOT NULL DEFAULT 0,
+				failed INTEGER NOT NULL DEFAULT 0,
+				error TEXT NOT NULL DEFAULT '',
+				completed_at DATETIME NOT NULL
+			);`,
+		Down: `
+			DROP TABLE backfill_window;`,
+	},
+	{
+		Version: 6,
+		Name:    "add topic to article and backfill_window",
+		// The articles which were fetched before topics existed are DACA articles.
+		Up: `
+			ALTER TABLE article ADD COLUMN topic VARCHAR(100) NOT NULL DEFAULT 'daca';
+			CREATE INDEX article_topic_idx ON article (topic, published_at);
+			ALTER TABLE backfill_window ADD COLUMN topic VARCHAR(100) NOT NULL DEFAULT 'daca';`,
+		Down: `
+			CREATE TABLE article_v5 (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				url VARCHAR(100) UNIQUE NOT NULL,
+				title VARCHAR(100) NOT NULL,
+				description VARCHAR(100),
+				source VARCHAR(100) NOT NULL,
+				author VARCHAR(100),
+				lede_img VARCHAR(100),
+				published_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL,
+				canonical_url VARCHAR(100) NOT NULL DEFAULT ''
+			);
+			INSERT INTO article_v5 (
+				id, url, title, description, source, author,
+				lede_img, published_at, created_at, canonical_url
+			)
+			SELECT
+				id, url, title, description, source, author,
+				lede_img, published_at, created_at, canonical_url
+			FROM article;
+			DROP TABLE article;
+			ALTER TABLE article_v5 RENAME TO article;
+			CREATE INDEX article_canonical_url_idx ON article (canonical_url);
+
+			CREATE TABLE backfill_window_v5 (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				window_from DATETIME NOT NULL,
+				window_to DATETIME NOT NULL,
+				status VARCHAR(20) NOT NULL,
+				fetched INTEGER NOT NULL DEFAULT 0,
+				inserted INTEGER NOT NULL DEFAULT 0,
+				updated INTEGER NOT NULL DEFAULT 0,
+				failed INTEGER NOT NULL DEFAULT 0,
+				error TEXT NOT NULL DEFAULT '',
+				completed_at DATETIME NOT NULL
+			);
+			INSERT INTO backfill_window_v5 (
+				id, window_from, window_to, status, fetched, inserted,
+				updated, failed, error, completed_at
+			)
+			SELECT
+				id, window_from, window_to, status, fetched, inserted,
+				updated, failed, error, completed_at
+			FROM backfill_window;
+			DROP TABLE backfill_window;
+			ALTER TABLE backfill_window_v5 RENAME TO backfill_window;`,
+	},
+	{
+		Version: 7,
+		Name:    "create task_lease",
+		Up: `
+			CREATE TABLE task_lease (
+				task VARCHAR(100) PRIMARY KEY,
+				holder VARCHAR(200) NOT NULL,
+				acquired_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL
+			);`,
+		Down: `
+			DROP TABLE task_lease;`,
+	},
+	{
+		Version: 8,
+		Name:    "create admin_user",
+		Up: `
+			CREATE TABLE admin_user (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username VARCHAR(50) UNIQUE NOT NULL,
+				password_hash VARCHAR(100) NOT NULL,
+				created_at DATETIME NOT NULL
+			);`,
+		Down: `
+			DROP TABLE admin_user;`,
+	},
+	{
+		Version: 9,
+		Name:    "create article_fts_pending",
+		// The search index was kept in sync by triggers, which fail every article
+		// insert in binaries built without FTS5. The application updates the index instead.
+		Up: `
+			DROP TRIGGER IF EXISTS article_fts_insert;
+			DROP TRIGGER IF EXISTS article_fts_delete;
+			DROP TRIGGER IF EXISTS article_fts_update;
+
+			CREATE TABLE article_fts_pending (
+				article_id INTEGER PRIMARY KEY
+			);`,
+		Down: `
+			DROP TABLE article_fts_pending;`,
+	},
+	{
+		Version: 10,
+		Name:    "add story to article",
+		// The stories are assigned in Go, see backfillStories.
+		Up: `
+			ALTER TABLE article ADD COLUMN story_id INTEGER NOT NULL DEFAULT 0;
+			CREATE INDEX article_story_idx ON article (story_id);`,
+		Down: `
+			CREATE TABLE article_v9 (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				url VARCHAR(100) UNIQUE NOT NULL,
+				title VARCHAR(100) NOT NULL,
+				description VARCHAR(100),
+				source VARCHAR(100) NOT NULL,
+				author VARCHAR(100),
+				lede_img VARCHAR(100),
+				published_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL,
+				canonical_url VARCHAR(100) NOT NULL DEFAULT '',
+				topic VARCHAR(100) NOT NULL DEFAULT 'daca'
+			);
+			INSERT INTO article_v9 (
+				id, url, title, description, source, author,
+				lede_img, published_at, created_at, canonical_url, topic
+			)
+			SELECT
+				id, url, title, description, source, author,
+				lede_img, published_at, created_at, canonical_url, topic
+			FROM article;
+			DROP TABLE article;
+			ALTER TABLE article_v9 RENAME TO article;
+			CREATE INDEX article_canonical_url_idx ON article (canonical_url);
+			CREATE INDEX article_topic_idx ON article (topic, published_at);`,
+	},
+	{
+		Version: 11,
+		Name:    "make article urls unique per topic",
+		// An article which matches more than one topic is saved once for each topic.
+		// Reverting keeps the article of the first topic, and unindexes the others.
+		Up: `
+			CREATE TABLE article_v11 (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				url VARCHAR(100) NOT NULL,
+				title VARCHAR(100) NOT NULL,
+				description VARCHAR(100),
+				source VARCHAR(100) NOT NULL,
+				author VARCHAR(100),
+				lede_img VARCHAR(100),
+				published_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL,
+				canonical_url VARCHAR(100) NOT NULL DEFAULT '',
+				topic VARCHAR(100) NOT NULL DEFAULT 'daca',
+				story_id INTEGER NOT NULL DEFAULT 0,
+				UNIQUE (url, topic)
+			);
+			INSERT INTO article_v11 (
+				id, url, title, description, source, author,
+				lede_img, published_at, created_at, canonical_url, topic, story_id
+			)
+			SELECT
+				id, url, title, description, source, author,
+				lede_img, published_at, created_at, canonical_url, topic, story_id
+			FROM article;
+			DROP TABLE article;
+			ALTER TABLE article_v11 RENAME TO article;
+			CREATE INDEX article_canonical_url_idx ON article (canonical_url);
+			CREATE INDEX article_topic_idx ON article (topic, published_at);
+			CREATE INDEX article_story_idx ON article (story_id);`,
+		Down: `
+			INSERT OR IGNORE INTO article_fts_pending (article_id)
+			SELECT id FROM article WHERE id NOT IN (SELECT MIN(id) FROM article GROUP BY url);
+
+			CREATE TABLE article_v10 (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				url VARCHAR(100) UNIQUE NOT NULL,
+				title VARCHAR(100) NOT NULL,
+				description VARCHAR(100),
+				source VARCHAR(100) NOT NULL,
+				author VARCHAR(100),
+				lede_img VARCHAR(100),
+				published_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL,
+				canonical_url VARCHAR(100) NOT NULL DEFAULT '',
+				topic VARCHAR(100) NOT NULL DEFAULT 'daca',
+				story_id INTEGER NOT NULL DEFAULT 0
+			);
+			INSERT INTO article_v10 (
+				id, url, title, description, source, author,
+				lede_img, published_at, created_at, canonical_url, topic, story_id
+			)
+			SELECT
+				id, url, title, description, source, author,
+				lede_img, published_at, created_at, canonical_url, topic, story_id
+			FROM article
+			WHERE id IN (SELECT MIN(id) FROM article GROUP BY url);
+			DROP TABLE article;
+			ALTER TABLE article_v10 RENAME TO article;
+			CREATE INDEX article_canonical_url_idx ON article (canonical_url);
+			CREATE INDEX article_topic_idx ON article (topic, published_at);
+			CREATE INDEX article_story_idx ON article (story_id);`,
+	},
+	{
+		Version: 12,
+		Name:    "add session_version to admin_user",
+		// The sessions of a user are revoked by incrementing the version, see RevokeSessions.
+		Up: `
+			ALTER TABLE admin_user ADD COLUMN session_version INTEGER NOT NULL DEFAULT 0;`,
+		Down: `
+			CREATE TABLE admin_user_v11 (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username VARCHAR(50) UNIQUE NOT NULL,
+				password_hash VARCHAR(100) NOT NULL,
+				created_at DATETIME NOT NULL
+			);
+			INSERT INTO admin_user_v11 (id, username, password_hash, created_at)
+			SELECT id, username, password_hash, created_at FROM admin_user;
+			DROP TABLE admin_user;
+			ALTER TABLE admin_user_v11 RENAME TO admin_user;`,
+	},
+	{
+		Version: 13,
+		Name:    "create admin_session_revoked",
+		// A signed out session is revoked until it expires, see RevokeSession.
+		Up: `
+			CREATE TABLE admin_session_revoked (
+				id VARCHAR(64) PRIMARY KEY,
+				expires_at DATETIME NOT NULL
+			);`,
+		Down: `
+			DROP TABLE admin_session_revoked;`,
+	},
+}
+
+// schemaMigrator applies an ordered set of migrations to a database.
+// It is shared by the sqlite and the postgres databases, which differ
+// only in their migrations and in how their schema is inspected.
+type schemaMigrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+
+	// table is the DDL of the schema_migrations table.
+	table string
+
+	tableExists func(name string) bool
+
+	// legacyVersion detects the schema version of a database
+	// which was created before migrations existed.
+	legacyVersion func() int
+}
+
+// migrator returns the schema migrator of the sqlite database.
+func (d *ServerDB) migrator() *schemaMigrator {
+	return &schemaMigrator{
+		db:         d.db,
+		migrations: Migrations,
+		table: `
+			CREATE TABLE schema_migrations (
+				version INTEGER PRIMARY KEY,
+				name VARCHAR(100) NOT NULL,
+				applied_at DATETIME NOT NULL
+			);`,
+		tableExists:   d.tableExists,
+		legacyVersion: d.legacyVersion,
+	}
+}
+
+// MigrationStatus lists all the migrations, and when they were applied.
+func (d *ServerDB) MigrationStatus() ([]MigrationStatus, error) {
+	return d.migrator().MigrationStatus()
+}
+
+// MigrateUp applies all the pending migrations, in order.
+func (d *ServerDB) MigrateUp() ([]Migration, error) {
+	return d.migrator().MigrateUp()
+}
+
+// MigrateDown reverts the applied migrations until the database is at the given version.
+func (d *ServerDB) MigrateDown(version int) ([]Migration, error) {
+	return d.migrator().MigrateDown(version)
+}
+
+// legacyVersion detects the schema version of a database
+// which was created by CreateTables, before migrations existed.
+func (d *ServerDB) legacyVersion() int {
+	if !d.tableExists("article") {
+		return 0
+	}
+
+	columns := []string{}
+	d.db.Select(&columns, `SELECT name FROM pragma_table_info('tasklog');`)
+	for _, column := range columns {
+		if column == "started_at" {
+			return 2
+		}
+	}
+	return 1
+}
+
+func (d *ServerDB) tableExists(name string) bool {
+	count := 0
+	d.db.Get(&count, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;`, name)
+	return count > 0
+}
+
+// createMigrationsTable creates the table which tracks the applied migrations.
+// A database which was created before migrations existed is adopted at the
+// version its schema matches, so that its tables are not created again.
+func (m *schemaMigrator) createMigrationsTable() error {
+	if m.tableExists("schema_migrations") {
+		return nil
+	}
+
+	if _, err := m.db.Exec(m.table); err != nil {
+		return err
+	}
+
+	adopted := 0
+	if m.legacyVersion != nil {
+		adopted = m.legacyVersion()
+	}
+	for _, migration := range m.migrations {
+		if migration.Version > adopted {
+			break
+		}
+		fmt.Printf("[migrate] adopting existing schema as version %v\n", migration.Version)
+		if err := recordMigration(m.db, migration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordMigration(db sqlx.Ext, migration Migration) error {
+	sql := db.Rebind(`
+		INSERT INTO schema_migrations (version, name, applied_at)
+		VALUES (?, ?, ?);`)
+	_, err := db.Exec(sql, migration.Version, migration.Name, time.Now().UTC())
+	return err
+}
+
+// MigrationStatus lists all the migrations, and when they were applied.
+func (m *schemaMigrator) MigrationStatus() ([]MigrationStatus, error) {
+	if err := m.createMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	applied := []struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}{}
+	if err := m.db.Select(&applied, `SELECT version, applied_at FROM schema_migrations;`); err != nil {
+		return nil, err
+	}
+
+	appliedAt := map[int]time.Time{}
+	for _, row := range applied {
+		appliedAt[row.Version] = row.AppliedAt
+	}
+
+	statuses := []MigrationStatus{}
+	for _, migration := range m.migrations {
+		statuses = append(statuses, MigrationStatus{Migration: migration, AppliedAt: appliedAt[migration.Version]})
+	}
+	return statuses, nil
+}
+
+// MigrateUp applies all the pending migrations, in order.
+// Each migration is applied in its own transaction.
+func (m *schemaMigrator) MigrateUp() ([]Migration, error) {
+	statuses, err := m.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := []Migration{}
+	for _, status := range statuses {
+		if status.Applied() {
+			continue
+		}
+
+		fmt.Printf("[migrate] applying %v: %v\n", status.Version, status.Name)
+		err := inTx(m.db, func(tx *sqlx.Tx) error {
+			if _, err := tx.Exec(status.Up); err != nil {
+				return err
+			}
+			return recordMigration(tx, status.Migration)
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("migration %v failed: %w", status.Version, err)
+		}
+		migrated = append(migrated, status.Migration)
+	}
+	return migrated, nil
+}
+
+// MigrateDown reverts the applied migrations, newest first,
+// until the database is at the given version.
+func (m *schemaMigrator) MigrateDown(version int) ([]Migration, error) {
+	statuses, err := m.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	migrated := []Migration{}
+	for i := len(statuses) - 1; i >= 0; i-- {
+		status := statuses[i]
+		if status.Version <= version || !status.Applied() {
+			continue
+		}
+
+		fmt.Printf("[migrate] reverting %v: %v\n", status.Version, status.Name)
+		err := inTx(m.db, func(tx *sqlx.Tx) error {
+			if _, err := tx.Exec(status.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(tx.Rebind(`DELETE FROM schema_migrations WHERE version = ?;`), status.Version)
+			return err
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("reverting migration %v failed: %w", status.Version, err)
+		}
+		migrated = append(migrated, status.Migration)
+	}
+	return migrated, nil
+}
+
+// inTx runs the function in a transaction, which is rolled back if an error is returned.
+func inTx(db *sqlx.DB, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
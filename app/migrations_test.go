@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// legacySchema is the schema created by CreateTables, before migrations existed.
+const legacySchema = `
+	CREATE TABLE IF NOT EXISTS article (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url VARCHAR(100) UNIQUE NOT NULL,
+		title VARCHAR(100) NOT NULL,
+		description VARCHAR(100),
+		source VARCHAR(100) NOT NULL,
+		author VARCHAR(100),
+		lede_img VARCHAR(100),
+		published_at DATETIME NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS tasklog (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task VARCHAR(100) NOT NULL,
+		manual BOOLEAN DEFAULT FALSE,
+		completed_at DATETIME NOT NULL
+	);`
+
+func TestMigrateUp(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+
+	migrated, err := db.MigrateUp()
+	is.NoErr(err)
+	is.Equal(len(migrated), len(Migrations)) // All migrations are applied
+
+	migrated, err = db.MigrateUp()
+	is.NoErr(err)
+	is.Equal(len(migrated), 0) // Migrating twice is a no-op
+
+	statuses, err := db.MigrationStatus()
+	is.NoErr(err)
+	for _, status := range statuses {
+		is.True(status.Applied())
+	}
+}
+
+func TestMigrate_AdoptsLegacySchema(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	db.db.MustExec(legacySchema)
+	db.db.MustExec(`
+		INSERT INTO tasklog (task, manual, completed_at)
+		VALUES ('UpdateArticles', false, '2020-06-25 10:00:00');`)
+
+	migrated, err := db.MigrateUp()
+	is.NoErr(err)
+	is.Equal(len(migrated), len(Migrations)-1) // The legacy schema is adopted as version 1
+	is.Equal(migrated[0].Version, 2)
+
+	recent, err := db.GetRecentTaskLog(context.Background(), TaskUpdateArticles)
+	is.NoErr(err)
+	is.Equal(recent.Status, TaskStatusSuccess)     // Existing runs are considered successful
+	is.Equal(recent.StartedAt, recent.CompletedAt) // Started at is backfilled
+	is.Equal(recent.CompletedAtDisplay(), "June 25, 2020")
+}
+
+func TestMigrateDown(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	_, err := db.MigrateUp()
+	is.NoErr(err)
+	is.NoErr(db.RecordTask(context.Background(), &TaskLog{Task: TaskUpdateArticles}))
+
+	migrated, err := db.MigrateDown(1)
+	is.NoErr(err)
+	is.Equal(len(migrated), len(Migrations)-1) // Reverted to version 1
+
+	statuses, err := db.MigrationStatus()
+	is.NoErr(err)
+	is.True(statuses[0].Applied())
+	is.True(!statuses[1].Applied())
+
+	// The tasklog rows are kept when the columns are dropped.
+	count := 0
+	is.NoErr(db.db.Get(&count, `SELECT COUNT(*) FROM tasklog;`))
+	is.Equal(count, 1)
+
+	// And the migration can be applied again.
+	_, err = db.MigrateUp()
+	is.NoErr(err)
+	recent, err := db.GetRecentTaskLog(context.Background(), TaskUpdateArticles)
+	is.NoErr(err)
+	is.Equal(recent.Status, TaskStatusSuccess)
+
+	_, err = db.MigrateDown(0)
+	is.NoErr(err)
+	is.True(!db.tableExists("article")) // All migrations are reverted
+}
+
+func TestMigrate_BackfillsCanonicalURLs(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	_, err := db.MigrateUp()
+	is.NoErr(err)
+	db.db.MustExec(`
+		INSERT INTO article (url, title, source, published_at, created_at)
+		VALUES ('http://www.example.com/story/?utm_source=rss', 'Story', 'cnn', '2020-06-25 10:00:00', '2020-06-25 10:00:00');`)
+
+	is.NoErr(db.Migrate())
+
+	canonicalURL := ""
+	is.NoErr(db.db.Get(&canonicalURL, `SELECT canonical_url FROM article;`))
+	is.Equal(canonicalURL, "https://example.com/story")
+}
+
+func TestMigrate_BackfillsStories(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	_, err := db.MigrateUp()
+	is.NoErr(err)
+
+	// Variants of a url which were saved before canonical urls existed.
+	db.db.MustExec(`
+		INSERT INTO article (url, title, source, published_at, created_at)
+		VALUES
+			('http://www.example.com/story/?utm_source=rss', 'Story', 'cnn', '2020-06-25 10:00:00', '2020-06-25 10:00:00'),
+			('https://example.com/story', 'Story, updated', 'cnn', '2020-06-25 12:00:00', '2020-06-25 12:00:00'),
+			('https://example.com/other', 'Other', 'cnn', '2020-06-25 12:00:00', '2020-06-25 12:00:00');`)
+
+	is.NoErr(db.Migrate())
+
+	stories := []int{}
+	is.NoErr(db.db.Select(&stories, `SELECT story_id FROM article ORDER BY id;`))
+	is.Equal(stories, []int{1, 1, 3}) // The variants are one story
+}
@@ -1,7 +1,9 @@
 package app
 
 import (
+	"encoding/json"
 	"fmt"
+	"html/template"
 	"math"
 	"strings"
 	"time"
@@ -12,16 +14,33 @@ import (
 const RecentArticleThreshold int = 3
 
 // Article represents a news article.
+// The json tags are the public shape of an article in the api.
 type Article struct {
-	ID          int       `db:"id"`
+	ID          int       `db:"id" json:"id"`
 	URL         string    `db:"url" json:"url"`
 	Title       string    `db:"title" json:"title"`
 	Description string    `db:"description" json:"description"`
-	Source      string    `db:"source"`
+	Source      string    `db:"source" json:"source"`
 	Author      string    `db:"author" json:"author"`
-	LedeImg     string    `db:"lede_img" json:"urlToImage"`
-	PublishedAt time.Time `db:"published_at" json:"publishedAt"`
-	CreatedAt   time.Time `db:"created_at"`
+	LedeImg     string    `db:"lede_img" json:"image"`
+	PublishedAt time.Time `db:"published_at" json:"published_at"`
+	CreatedAt   time.Time `db:"created_at" json:"-"`
+
+	// Topic is the slug of the topic the article was fetched for.
+	Topic string `db:"topic" json:"topic"`
+
+	// CanonicalURL is the normalized url, see CanonicalURL.
+	CanonicalURL string `db:"canonical_url" json:"-"`
+
+	// StoryID is the id of the first article of the story, see assignStory.
+	StoryID int `db:"story_id" json:"-"`
+
+	// Duplicates are the other articles of the same story, see attachDuplicates.
+	Duplicates []*Article `db:"-" json:"-"`
+
+	// Search results
+	Rank    float64 `db:"rank" json:"-"`
+	Snippet string  `db:"snippet" json:"-"`
 }
 
 func (a *Article) DisplayTitle() string {
@@ -30,12 +49,20 @@ func (a *Article) DisplayTitle() string {
 }
 
 func (a *Article) DisplayDescription() string {
+	return trimText(stripListTags(a.Description), 130)
+}
+
+// DisplaySnippet is the search snippet, with the matched terms highlighted.
+func (a *Article) DisplaySnippet() template.HTML {
+	return renderSnippet(stripListTags(a.Snippet))
+}
+
+func stripListTags(text string) string {
 	htmlTags := []string{"<ol>", "</ol>", "<ul>", "</ul>", "<li>", "</li>"}
-	description := a.Description
 	for _, tag := range htmlTags {
-		description = strings.ReplaceAll(description, tag, "")
+		text = strings.ReplaceAll(text, tag, "")
 	}
-	return trimText(description, 130)
+	return text
 }
 
 func (a *Article) getPublishedAtDifference() float64 {
@@ -72,20 +99,38 @@ func trimText(text string, truncLength int) string {
 	return text
 }
 
-func earliestPubDate(aa []*Article) string {
-	if len(aa) == 0 {
-		return ""
-	}
-
-	return aa[len(aa)-1].PublishedAt.Format("2006-01-02 15:04:05")
-}
+// Task statuses.
+const (
+	TaskStatusSuccess = "success"
+	TaskStatusPartial = "partial"
+	TaskStatusFailed  = "failed"
+	TaskStatusSkipped = "skipped"
+)
 
 // TaskLog keeps a record of varios tasks being run.
 type TaskLog struct {
 	ID          int       `db:"id"`
 	Task        string    `db:"task"`
 	Manual      bool      `db:"manual"`
+	Status      string    `db:"status"`
+	StartedAt   time.Time `db:"started_at"`
 	CompletedAt time.Time `db:"completed_at"`
+	Fetched     int       `db:"fetched"`
+	Inserted    int       `db:"inserted"`
+	Duplicates  int       `db:"duplicates"` // Articles which were already saved, and unchanged.
+	Updated     int       `db:"updated"`    // Articles which were already saved, and updated.
+	Failed      int       `db:"failed"`     // Articles which could not be saved.
+	Sources     string    `db:"sources"`    // JSON encoded []TaskSourceStat
+	Error       string    `db:"error"`
+}
+
+// TaskSourceStat is the per-source breakdown of a task run.
+type TaskSourceStat struct {
+	Source  string `json:"source"`
+	Fetched int    `json:"fetched"`
+	Pages   int    `json:"pages,omitempty"`
+	Results int    `json:"results,omitempty"`
+	Error   string `json:"error,omitempty"`
 }
 
 func (t *TaskLog) CompletedAtDisplay() string {
@@ -94,3 +139,26 @@ func (t *TaskLog) CompletedAtDisplay() string {
 	}
 	return t.CompletedAt.Format("January 02, 2006")
 }
+
+// Duration is how long the task took to run.
+func (t *TaskLog) Duration() time.Duration {
+	if t.StartedAt.IsZero() || t.CompletedAt.IsZero() {
+		return 0
+	}
+	return t.CompletedAt.Sub(t.StartedAt)
+}
+
+// SourceStats decodes the per-source breakdown.
+func (t *TaskLog) SourceStats() []TaskSourceStat {
+	stats := []TaskSourceStat{}
+	if t.Sources != "" {
+		json.Unmarshal([]byte(t.Sources), &stats)
+	}
+	return stats
+}
+
+// SetSourceStats encodes the per-source breakdown.
+func (t *TaskLog) SetSourceStats(stats []TaskSourceStat) {
+	data, _ := json.Marshal(stats)
+	t.Sources = string(data)
+}
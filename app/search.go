@@ -0,0 +1,364 @@
+package app
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Sort orders for article queries.
+const (
+	SortDate      = "date"
+	SortRelevance = "relevance"
+)
+
+// ArticleQuery describes a page of articles to fetch.
+type ArticleQuery struct {
+	// Text is the search text. See ftsQuery for the supported syntax.
+	Text string
+
+	// Before is the cursor of the page. For date ordering it is a published date,
+	// and for relevance ordering it is the rank and id of the last article.
+	Before string
+
+	// Sort is the order of the results. Relevance ordering requires search text.
+	Sort string
+
+	// Topic limits the articles to a topic. All the topics are queried when empty.
+	Topic string
+
+	// Stories groups the articles of a story. Only the most recent matching article
+	// of each story is paged, and the other articles are its Duplicates.
+	Stories bool
+
+	// Filters
+	Sources []string  // Limit to any of the sources.
+	From    time.Time // Limit by PublishDate >= (inclusive day).
+	To      time.Time // Limit by PublishDate <= (inclusive day).
+	Author  string    // Limit by author name.
+}
+
+// filterSQL builds the SQL conditions for the query filters.
+// Each condition is prefixed with 'AND', and uses the given table alias.
+// Dates are converted to query args with timeArg, because sqlite
+// compares formatted strings, while postgres compares timestamps.
+func (q ArticleQuery) filterSQL(alias string, timeArg func(time.Time) interface{}) (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if q.Topic != "" {
+		conditions = append(conditions, alias+".topic = ?")
+		args = append(args, q.Topic)
+	}
+	if len(q.Sources) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(q.Sources)), ", ")
+		conditions = append(conditions, fmt.Sprintf("%v.source IN (%v)", alias, placeholders))
+		for _, source := range q.Sources {
+			args = append(args, source)
+		}
+	}
+	if !q.From.IsZero() {
+		conditions = append(conditions, alias+".published_at >= ?")
+		args = append(args, timeArg(q.From))
+	}
+	if !q.To.IsZero() {
+		conditions = append(conditions, alias+".published_at < ?")
+		args = append(args, timeArg(q.To.AddDate(0, 0, 1)))
+	}
+	if q.Author != "" {
+		conditions = append(conditions, fmt.Sprintf("LOWER(%v.author) LIKE LOWER(?)", alias))
+		args = append(args, "%"+q.Author+"%")
+	}
+
+	sql := ""
+	for _, condition := range conditions {
+		sql += " AND " + condition
+	}
+	return sql, args
+}
+
+// pageSQL selects a page of the articles which match the query. The matches are
+// selected as 'm', so that the cursor condition and the order can refer to them.
+// The articles of a story are grouped before the cursor is applied, so that a story
+// which was listed on a previous page is not listed again.
+//
+// The last arg of the query is the page size.
+func (q ArticleQuery) pageSQL(matches, cursor, order string) string {
+	if !q.Stories {
+		return `
+			SELECT *
+			FROM (` + matches + `) m
+			WHERE ` + cursor + `
+			ORDER BY ` + order + `
+			LIMIT ?;`
+	}
+
+	// Articles without a story are their own story.
+	return `
+		WITH matches AS (` + matches + `),
+		stories AS (
+			SELECT id, ROW_NUMBER() OVER (
+				PARTITION BY COALESCE(NULLIF(story_id, 0), id)
+				ORDER BY published_at DESC, id
+			) AS story_row
+			FROM matches
+		)
+		SELECT m.*
+		FROM matches m
+		JOIN stories s ON s.id = m.id
+		WHERE s.story_row = 1 AND ` + cursor + `
+		ORDER BY ` + order + `
+		LIMIT ?;`
+}
+
+// HasFilters reports whether any of the filters are set.
+func (q ArticleQuery) HasFilters() bool {
+	return len(q.Sources) > 0 || !q.From.IsZero() || !q.To.IsZero() || q.Author != ""
+}
+
+// HasSource reports whether the source is one of the source filters.
+func (q ArticleQuery) HasSource(source string) bool {
+	for _, s := range q.Sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// Params encodes the search text and filters as url query params.
+// The cursor is not included, so the params link to the first page.
+func (q ArticleQuery) Params() url.Values {
+	params := url.Values{}
+	if q.Text != "" {
+		params.Set("q", q.Text)
+	}
+	if q.Sort != "" {
+		params.Set("sort", q.Sort)
+	}
+	for _, source := range q.Sources {
+		params.Add("source", source)
+	}
+	if !q.From.IsZero() {
+		params.Set("from", q.From.Format("2006-01-02"))
+	}
+	if !q.To.IsZero() {
+		params.Set("to", q.To.Format("2006-01-02"))
+	}
+	if q.Author != "" {
+		params.Set("author", q.Author)
+	}
+	return params
+}
+
+// SortURL links to the first page of the query with the given sort order.
+func (q ArticleQuery) SortURL(sort string) string {
+	q.Sort = sort
+	return q.Path() + "?" + q.Params().Encode()
+}
+
+// Path is the path of the page of the query's topic.
+func (q ArticleQuery) Path() string {
+	if topic, ok := GetTopic(q.Topic); ok {
+		return topic.URL("")
+	}
+	return "/"
+}
+
+// FromDisplay formats the 'from' filter for a date input.
+func (q ArticleQuery) FromDisplay() string {
+	if q.From.IsZero() {
+		return ""
+	}
+	return q.From.Format("2006-01-02")
+}
+
+// ToDisplay formats the 'to' filter for a date input.
+func (q ArticleQuery) ToDisplay() string {
+	if q.To.IsZero() {
+		return ""
+	}
+	return q.To.Format("2006-01-02")
+}
+
+// sortOrder normalizes the sort order of the query. A search with a cursor which
+// is not a relevance cursor, such as the published date cursor of a link from
+// before relevance ordering, continues in date order.
+func (q ArticleQuery) sortOrder() string {
+	if q.Sort != SortRelevance || strings.TrimSpace(q.Text) == "" {
+		return SortDate
+	}
+	if q.Before != "" {
+		if _, _, err := parseRelevanceCursor(q.Before); err != nil {
+			return SortDate
+		}
+	}
+	return SortRelevance
+}
+
+// ArticlePage is a page of articles.
+type ArticlePage struct {
+	Articles   []*Article `json:"articles"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	HasMore    bool       `json:"has_more"`
+	Sort       string     `json:"sort,omitempty"`
+}
+
+// nextCursor returns the cursor which fetches the page after the given articles.
+func nextCursor(articles []*Article, sort string) string {
+	if len(articles) == 0 {
+		return ""
+	}
+
+	last := articles[len(articles)-1]
+	if sort == SortRelevance {
+		return fmt.Sprintf("%v,%v", strconv.FormatFloat(last.Rank, 'g', -1, 64), last.ID)
+	}
+	return last.PublishedAt.UTC().Format(CursorFormat)
+}
+
+// parseRelevanceCursor parses a cursor created by nextCursor for relevance ordering.
+func parseRelevanceCursor(cursor string) (float64, int, error) {
+	parts := strings.Split(cursor, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid relevance cursor %q", cursor)
+	}
+
+	rank, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	return rank, id, nil
+}
+
+// ftsQuery converts the search text into an FTS5 query.
+//
+// The supported syntax is:
+//   - "quoted phrases"
+//   - OR, NOT and AND operators
+//   - prefix* matching
+//
+// Every other term is quoted, so that punctuation in the search
+// text (ex: 'covid-19') does not cause FTS5 syntax errors.
+//
+// An empty query is returned when the text has no terms (ex: '*'), or when a NOT
+// has no term before it to exclude from (ex: 'NOT visa'). Such a search matches
+// no articles, rather than dropping the NOT and matching the excluded term.
+func ftsQuery(text string) string {
+	terms := []string{}
+	for _, token := range tokenizeSearch(text) {
+		last := ""
+		if len(terms) > 0 {
+			last = terms[len(terms)-1]
+		}
+
+		switch {
+		case isFTSOperator(token) && last != "" && !isFTSOperator(last):
+			// Operators are only valid between two terms.
+			terms = append(terms, token)
+		case token == "NOT" && last == "AND":
+			// 'AND NOT' is written as NOT in FTS5.
+			terms[len(terms)-1] = token
+		case token == "NOT":
+			// A NOT with no term to exclude from matches no articles.
+			return ""
+		case isFTSOperator(token):
+			// Other misplaced operators are dropped.
+		case strings.HasPrefix(token, `"`):
+			if phrase := strings.Trim(token, `"`); phrase != "" {
+				terms = append(terms, quoteFTS(phrase))
+			}
+		case strings.HasSuffix(token, "*") && isWord(strings.TrimRight(token, "*")):
+			terms = append(terms, strings.TrimRight(token, "*")+"*")
+		default:
+			if term := strings.Trim(token, "*"); term != "" {
+				terms = append(terms, quoteFTS(term))
+			}
+		}
+	}
+
+	// Drop a trailing operator.
+	if len(terms) > 0 && isFTSOperator(terms[len(terms)-1]) {
+		terms = terms[:len(terms)-1]
+	}
+	return strings.Join(terms, " ")
+}
+
+// tokenizeSearch splits the search text on whitespace, keeping quoted phrases together.
+func tokenizeSearch(text string) []string {
+	tokens := []string{}
+	current := strings.Builder{}
+	inPhrase := false
+
+	for _, r := range text {
+		switch {
+		case r == '"':
+			if inPhrase {
+				current.WriteRune(r)
+				tokens = append(tokens, current.String())
+				current.Reset()
+			} else {
+				if current.Len() > 0 {
+					tokens = append(tokens, current.String())
+					current.Reset()
+				}
+				current.WriteRune(r)
+			}
+			inPhrase = !inPhrase
+		case unicode.IsSpace(r) && !inPhrase:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+func isFTSOperator(term string) bool {
+	return term == "OR" || term == "NOT" || term == "AND"
+}
+
+func isWord(text string) bool {
+	if text == "" {
+		return false
+	}
+	for _, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func quoteFTS(text string) string {
+	return `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+}
+
+// Snippet highlights are marked with private use characters, so that the
+// snippet can be HTML escaped before the highlights are converted to tags.
+const (
+	snippetStart = "\ue000"
+	snippetEnd   = "\ue001"
+)
+
+// renderSnippet escapes the snippet and converts the highlights into <mark> tags.
+func renderSnippet(snippet string) template.HTML {
+	escaped := template.HTMLEscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, snippetStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, snippetEnd, "</mark>")
+	return template.HTML(escaped)
+}
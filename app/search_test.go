@@ -0,0 +1,341 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+// Test Helpers
+
+// requireFTS skips the test when the FTS5 extension is not compiled in.
+// Run the tests with `-tags sqlite_fts5` to enable it.
+func requireFTS(t *testing.T, db *ServerDB) {
+	if !db.fts {
+		t.Skip("FTS5 is unavailable, build with -tags sqlite_fts5")
+	}
+}
+
+func insertSearchArticles(db Database) {
+	published := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	articles := []*Article{
+		{Title: "Supreme Court blocks DACA repeal", Description: "A win for Dreamers.", Source: "cnn"},
+		{Title: "Dreamer graduates", Description: "Celebrating the class of 2020.", Source: "abc-news", Author: "Jane Doe"},
+		{Title: "Citizenship bill introduced", Description: "The bill offers a path to citizenship for dreamers.", Source: "the-hill"},
+		{Title: "Economy update", Description: "Markets are <ol>up</ol>.", Source: "bloomberg"},
+	}
+	for i, article := range articles {
+		article.URL = fmt.Sprintf("https://example.com/%v", i)
+		article.PublishedAt = published.AddDate(0, 0, i)
+		article.CreatedAt = published
+	}
+	db.InsertArticles(context.Background(), articles)
+}
+
+func articleTitles(articles []*Article) []string {
+	titles := []string{}
+	for _, article := range articles {
+		titles = append(titles, article.Title)
+	}
+	return titles
+}
+
+// ------------------------------------------------------------------
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		text  string
+		query string
+	}{
+		{"daca", `"daca"`},
+		{"covid-19 relief", `"covid-19" "relief"`},
+		{`"path to citizenship"`, `"path to citizenship"`},
+		{"dreamer OR daca", `"dreamer" OR "daca"`},
+		{"daca NOT trump", `"daca" NOT "trump"`},
+		{"immigra*", `immigra*`},
+		{"OR daca AND", `"daca"`},
+		{`say "hi`, `"say" "hi"`},
+		{"daca AND NOT trump", `"daca" NOT "trump"`},
+		{"OR", ""},
+		{"*", ""},
+		{`"`, ""},
+		{"NOT visa", ""},         // Not a search for visa
+		{"* NOT visa", ""},       // Not a search for visa
+		{"daca OR NOT visa", ""}, // Not a search for daca or visa
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(ftsQuery(tt.text), tt.query)
+		})
+	}
+}
+
+func TestRenderSnippet(t *testing.T) {
+	is := is.New(t)
+
+	snippet := renderSnippet("<b>A win</b> for " + snippetStart + "Dreamers" + snippetEnd)
+	is.Equal(string(snippet), "&lt;b&gt;A win&lt;/b&gt; for <mark>Dreamers</mark>") // Text is escaped, highlights are not
+}
+
+func TestGetArticles_Search(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+	insertSearchArticles(db)
+
+	// Matches the description and author, not only the title.
+	page := getArticles(t, db, ArticleQuery{Text: "celebrating", Sort: SortDate})
+	is.Equal(articleTitles(page.Articles), []string{"Dreamer graduates"})
+
+	page = getArticles(t, db, ArticleQuery{Text: "Jane", Sort: SortDate})
+	is.Equal(articleTitles(page.Articles), []string{"Dreamer graduates"})
+
+	// No search text returns all articles, newest first.
+	page = getArticles(t, db, ArticleQuery{})
+	is.Equal(len(page.Articles), 4)
+	is.Equal(page.Sort, SortDate)
+}
+
+func TestGetArticles_FullTextSearch(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+	requireFTS(t, db)
+	insertSearchArticles(db)
+
+	search := func(text string) []string {
+		return articleTitles(getArticles(t, db, ArticleQuery{Text: text, Sort: SortDate}).Articles)
+	}
+
+	// Word stems match.
+	is.Equal(search("dreamers"), []string{"Citizenship bill introduced", "Dreamer graduates", "Supreme Court blocks DACA repeal"})
+	// Phrases.
+	is.Equal(search(`"path to citizenship"`), []string{"Citizenship bill introduced"})
+	// Operators.
+	is.Equal(search("daca OR economy"), []string{"Economy update", "Supreme Court blocks DACA repeal"})
+	is.Equal(search("dreamer NOT citizenship"), []string{"Dreamer graduates", "Supreme Court blocks DACA repeal"})
+	// Prefixes.
+	is.Equal(search("econ*"), []string{"Economy update"})
+	// Punctuation does not cause syntax errors.
+	is.Equal(search("covid-19"), []string{})
+	// Searches without terms match no articles, and do not cause syntax errors.
+	for _, text := range []string{"OR", "*", `"`, "NOT dreamer"} {
+		is.Equal(search(text), []string{})
+		page, err := db.GetArticles(context.Background(), ArticleQuery{Text: text, Sort: SortRelevance})
+		is.NoErr(err)
+		is.Equal(len(page.Articles), 0)
+	}
+
+	// Relevance ranks title matches first.
+	page := getArticles(t, db, ArticleQuery{Text: "citizenship", Sort: SortRelevance})
+	is.Equal(page.Sort, SortRelevance)
+	is.Equal(page.Articles[0].Title, "Citizenship bill introduced")
+	is.True(page.Articles[0].Snippet != "") // Snippets are returned
+
+	snippet := string(page.Articles[0].DisplaySnippet())
+	is.Equal(snippet, "The bill offers a path to <mark>citizenship</mark> for dreamers.")
+}
+
+func TestGetArticles_FullTextSearchIndexSync(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+	requireFTS(t, db)
+	insertSearchArticles(db)
+
+	search := func(text string) int {
+		return len(getArticles(t, db, ArticleQuery{Text: text, Sort: SortDate}).Articles)
+	}
+	is.Equal(search("economy"), 1)
+
+	_, err := db.InsertArticles(context.Background(), []*Article{
+		{URL: "https://example.com/3", Title: "Markets rally", Source: "bloomberg"},
+	})
+	is.NoErr(err)
+
+	is.Equal(search("economy"), 0) // Updated articles are re-indexed when they are saved
+	is.Equal(search("rally"), 1)
+
+	// A binary without FTS5 marks the index as stale, and it is rebuilt.
+	db.db.MustExec(`
+		DELETE FROM article WHERE source = 'cnn';
+		INSERT INTO article_fts_pending (article_id) VALUES (0);`)
+	is.NoErr(db.Migrate())
+
+	is.Equal(search("supreme"), 0) // Deleted articles are removed from the index
+	is.Equal(search("rally"), 1)
+
+	pending := 0
+	is.NoErr(db.db.Get(&pending, `SELECT COUNT(*) FROM article_fts_pending;`))
+	is.Equal(pending, 0)
+}
+
+func TestInsertArticles_WithoutSearchIndex(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+	db.fts = false // As in a binary without FTS5
+	insertSearchArticles(db)
+
+	pending := 0
+	is.NoErr(db.db.Get(&pending, `SELECT COUNT(*) FROM article_fts_pending;`))
+	is.Equal(pending, 0) // Saved articles are not queued for an index
+}
+
+// TestMigrate_LegacySearchIndex opens a database whose search index was kept in sync by
+// triggers. Without FTS5, the triggers failed every insert. The index is faked when FTS5
+// is not compiled in, since it cannot be created.
+func TestMigrate_LegacySearchIndex(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+	insertSearchArticles(db)
+	_, err := db.MigrateDown(8)
+	is.NoErr(err)
+
+	legacyIndex := `
+		CREATE VIRTUAL TABLE article_fts USING fts5(
+			title, description, author, source,
+			content='article', content_rowid='id',
+			tokenize='porter unicode61'
+		)`
+	if db.fts {
+		db.db.MustExec(`DROP TABLE article_fts;`)
+		db.db.MustExec(legacyIndex)
+		db.db.MustExec(`INSERT INTO article_fts (article_fts) VALUES ('rebuild');`)
+	} else {
+		// A table stands in for the index. A virtual table can not be faked,
+		// because the later migrations which alter the article table reject it.
+		db.db.MustExec(`CREATE TABLE article_fts (title, description, author, source);`)
+	}
+	db.db.MustExec(`
+		CREATE TRIGGER article_fts_insert AFTER INSERT ON article BEGIN
+			INSERT INTO article_fts (rowid, title, description, author, source)
+			VALUES (new.id, new.title, new.description, new.author, new.source);
+		END;`)
+
+	is.NoErr(db.Migrate())
+	triggers := 0
+	is.NoErr(db.db.Get(&triggers, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger';`))
+	is.Equal(triggers, 0) // The triggers of the legacy index are dropped
+
+	result, err := db.InsertArticles(context.Background(), []*Article{
+		{URL: "https://example.com/new", Title: "Dreamers rally in Austin", Source: "cnn", PublishedAt: time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	is.NoErr(err)
+	is.Equal(result.Failed(), 0) // Articles can be saved with or without FTS5
+
+	page := getArticles(t, db, ArticleQuery{Text: "rally"})
+	is.Equal(articleTitles(page.Articles), []string{"Dreamers rally in Austin"})
+	page = getArticles(t, db, ArticleQuery{Text: "citizenship"})
+	is.Equal(len(page.Articles), 1) // The articles saved before are searchable
+}
+
+func TestGetArticles_RelevancePagination(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+	requireFTS(t, db)
+
+	// Articles mention 'daca' a varying number of times, so they have different ranks.
+	articles := []*Article{}
+	for i := 0; i < PageSize+3; i++ {
+		articles = append(articles, &Article{
+			URL:         fmt.Sprintf("https://example.com/%v", i),
+			Title:       fmt.Sprintf("Article %v", i),
+			Description: strings.Repeat("daca ", i%4+1),
+			Source:      "cnn",
+			PublishedAt: time.Now().UTC(),
+			CreatedAt:   time.Now().UTC(),
+		})
+	}
+	db.InsertArticles(context.Background(), articles)
+
+	seen := map[int]bool{}
+	query := ArticleQuery{Text: "daca", Sort: SortRelevance}
+
+	first := getArticles(t, db, query)
+	is.Equal(len(first.Articles), PageSize)
+	is.True(first.HasMore)
+
+	query.Before = first.NextCursor
+	second := getArticles(t, db, query)
+	is.Equal(len(second.Articles), 3)
+	is.True(!second.HasMore)
+
+	for _, article := range append(first.Articles, second.Articles...) {
+		is.True(!seen[article.ID]) // No article is returned twice
+		seen[article.ID] = true
+	}
+	is.True(first.Articles[PageSize-1].Rank <= second.Articles[0].Rank) // Pages are ordered by rank
+}
+
+func TestMigrate_BuildsSearchIndex(t *testing.T) {
+	is := is.New(t)
+
+	// Articles which were inserted before the search index existed.
+	db := newTestDB(t)
+	db.db.MustExec(Migrations[0].Up)
+	db.db.MustExec(`
+		INSERT INTO article (url, title, description, source, author, lede_img, published_at, created_at)
+		VALUES ('https://example.com/1', 'Dreamers rally', '', 'cnn', '', '', '2020-06-25 10:00:00', '2020-06-25 10:00:00');`)
+
+	is.NoErr(db.Migrate())
+	requireFTS(t, db)
+
+	page := getArticles(t, db, ArticleQuery{Text: "dreamer", Sort: SortDate})
+	is.Equal(len(page.Articles), 1) // Existing articles are indexed
+}
+
+func TestGetArticles_Filters(t *testing.T) {
+	is := is.New(t)
+
+	db := newTestDB(t)
+	is.NoErr(db.Migrate())
+	insertSearchArticles(db)
+
+	search := func(query ArticleQuery) []string {
+		query.Sort = SortDate
+		return articleTitles(getArticles(t, db, query).Articles)
+	}
+
+	is.Equal(search(ArticleQuery{Sources: []string{"cnn", "the-hill"}}), []string{"Citizenship bill introduced", "Supreme Court blocks DACA repeal"})
+	is.Equal(search(ArticleQuery{Author: "jane"}), []string{"Dreamer graduates"})
+
+	// Date filters include the whole day.
+	from := time.Date(2020, 6, 2, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 6, 3, 0, 0, 0, 0, time.UTC)
+	is.Equal(search(ArticleQuery{From: from, To: to}), []string{"Citizenship bill introduced", "Dreamer graduates"})
+
+	// Filters compose with the search text and the cursor.
+	is.Equal(search(ArticleQuery{Text: "dreamer", From: from}), []string{"Citizenship bill introduced", "Dreamer graduates"})
+	is.Equal(search(ArticleQuery{From: from, Before: "2020-06-03 00:00:00"}), []string{"Dreamer graduates"})
+}
+
+func TestArticleQuery_Params(t *testing.T) {
+	is := is.New(t)
+
+	query := ArticleQuery{
+		Text:    "daca",
+		Before:  "2020-06-15 00:00:00",
+		Sources: []string{"cnn", "fox-news"},
+		From:    time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+		Author:  "Jane",
+	}
+
+	// The cursor is not included in the links.
+	is.Equal(query.SortURL(SortDate), "/?author=Jane&from=2020-06-01&q=daca&sort=date&source=cnn&source=fox-news")
+}
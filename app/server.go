@@ -1,6 +1,9 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -16,20 +19,39 @@ import (
 const Version = "0.1.2"
 
 // NewServer creates a new Server and initializes resources.
-func NewServer() *Server {
+// The database is opened with the given DSN, see NewDB.
+func NewServer(dsn string) *Server {
 	s := Server{}
 	fmt.Println("[setup] templates")
 	s.Templates = s.GetTemplates()
 
 	fmt.Println("[setup] database")
-	s.DB = NewDB()
-	s.DB.CreateTables()
+	db, err := NewDB(dsn)
+	if err != nil {
+		log.Fatalf("Could not open the database: %v\n", err)
+	}
+	s.DB = db
+	if err := s.DB.Migrate(); err != nil {
+		log.Fatalf("Could not migrate the database: %v\n", err)
+	}
+
+	s.Fetches = NewFetchRunner(s.DB)
+	s.Sessions = NewSessionStore("")
+	s.Logins = NewLoginLimiter()
 
 	fmt.Println("[setup] router")
 	s.Router = s.GetRouter()
 	return &s
 }
 
+// tagColors are the colors of the featured tags, in turn.
+var tagColors = []string{
+	"bg-indigo-100 hover:bg-indigo-200 text-indigo-700",
+	"bg-purple-100 hover:bg-purple-200 text-purple-700",
+	"bg-indigo-100 hover:bg-indigo-200 text-indigo-700",
+	"bg-blue-100 hover:bg-blue-200 text-blue-700",
+}
+
 // GetTemplates sets up the templates.
 func (s *Server) GetTemplates() *template.Template {
 	templatePath := "templates/*.html"
@@ -37,6 +59,18 @@ func (s *Server) GetTemplates() *template.Template {
 		"Slugify": func(s string) string {
 			return strings.ReplaceAll(strings.ToLower(s), " ", "-")
 		},
+		"TagColor": func(i int) string {
+			return tagColors[i%len(tagColors)]
+		},
+		"PageSize": func() int {
+			return PageSize
+		},
+		"FormatTime": func(t time.Time) string {
+			if t.IsZero() {
+				return "-"
+			}
+			return t.UTC().Format("2006-01-02 15:04 MST")
+		},
 	}
 
 	tmpl, err := template.New("").Funcs(templateFuncs).ParseGlob(templatePath)
@@ -52,28 +86,71 @@ type Server struct {
 	Templates *template.Template
 	Router    *mux.Router
 	DB        Database
+
+	// Scheduler runs the jobs. It is nil when the jobs are not scheduled.
+	Scheduler *Scheduler
+
+	// Fetches runs the fetches started from the admin pages.
+	Fetches *FetchRunner
+
+	// Sessions signs the cookies of the admin sessions.
+	Sessions *SessionStore
+
+	// StaleSyncAdvisory reports a stale last sync on /readyz as a warning,
+	// instead of failing readiness.
+	StaleSyncAdvisory bool
+
+	// Logins limits the failed sign-ins to the admin pages.
+	Logins *LoginLimiter
+
+	// ClientIPHeader is the header with the address of the client, which is set
+	// by the proxy in front of the server, ex: "X-Forwarded-For".
+	ClientIPHeader string
 }
 
 // TemplateContext stores data to render templates with.
 type TemplateContext struct {
-	Articles      []*Article
-	SearchText    string
-	Pagination    bool
-	PubDateCursor string
-	PartialPage   bool
-	UpdatedAt     string
-	LastSync      string
-	Version       string
+	Topic        *Topic
+	Articles     []*Article
+	Query        ArticleQuery
+	Sources      []string
+	SearchText   string
+	Sort         string
+	Pagination   bool
+	Cursor       string
+	PartialPage  bool
+	UpdatedAt    string
+	LastSync     string
+	Version      string
+	StatusChecks []StatusCheck
+	Jobs         []JobStatus
+	Topics       []*Topic
+	FetchRuns    []*FetchRun
+	FetchRun     *FetchRun
+	Form         map[string]string
+	FormError    string
+	User         *User
+	CSRFToken    string
+	Next         string
 }
 
 func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
-	// Get query params and normalize.
-	searchText := r.URL.Query().Get("q")
+	topic, ok := requestTopic(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
 
-	beforePubDate := r.URL.Query().Get("before")
-	if beforePubDate == "" {
-		beforePubDate = time.Now().UTC().Format("2006-01-02 15:04:05")
+	// Get query params and normalize.
+	// Invalid filters and cursors are ignored.
+	query, _ := articleQueryParams(r)
+	if validateCursor(query) != nil {
+		query.Before = ""
 	}
+	query.Topic = topic.Slug
+
+	// Syndicated copies of a story are grouped under one article.
+	query.Stories = true
 
 	fullPageParam := r.URL.Query().Get("fullpage")
 	if fullPageParam == "" {
@@ -82,47 +159,117 @@ func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
 	fullPage, _ := strconv.ParseBool(fullPageParam)
 
 	// Fetch articles.
-	articles, moreResults := s.DB.GetArticles(searchText, beforePubDate)
+	page, err := s.DB.GetArticles(r.Context(), query)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
 
 	// Fetch tasklog.
-	tasklog := s.DB.GetRecentTaskLog(TaskUpdateArticles)
+	tasklog, err := s.DB.GetRecentTaskLog(r.Context(), TaskUpdateArticles)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
 
 	// Prepare template data.
 	data := TemplateContext{
-		Articles:      articles,
-		SearchText:    searchText,
-		Pagination:    moreResults,
-		PubDateCursor: earliestPubDate(articles),
-		LastSync:      tasklog.CompletedAtDisplay(),
-		Version:       Version,
+		Topic:      topic,
+		Articles:   page.Articles,
+		Query:      query,
+		SearchText: query.Text,
+		Sort:       page.Sort,
+		Pagination: page.HasMore,
+		Cursor:     page.NextCursor,
+		LastSync:   tasklog.CompletedAtDisplay(),
+		Version:    Version,
 	}
 
-	// fmt.Printf("searchText: %v, before: %v, results: %v, moreResults: %v\n", searchText, beforePubDate, len(articles), moreResults)
-
 	// Render page.
 	if !fullPage {
 		data.PartialPage = true
 		s.Templates.ExecuteTemplate(w, "articles", data)
 		return
 	}
+	if data.Sources, err = s.DB.GetArticleSources(r.Context()); err != nil {
+		s.serverError(w, r, err)
+		return
+	}
 	s.Templates.ExecuteTemplate(w, "index", data)
 }
 
+// articleQueryParams reads the article query from the query params.
+// Search results are ordered by relevance, unless 'sort=date' is given.
+// An error is returned for invalid filter values, which are left unset.
+func articleQueryParams(r *http.Request) (ArticleQuery, error) {
+	params := r.URL.Query()
+	query := ArticleQuery{
+		Text:   params.Get("q"),
+		Before: params.Get("before"),
+		Sort:   params.Get("sort"),
+		Author: strings.TrimSpace(params.Get("author")),
+	}
+	if query.Sort != SortDate {
+		query.Sort = SortRelevance
+	}
+
+	for _, source := range params["source"] {
+		if source = strings.TrimSpace(source); source != "" {
+			query.Sources = append(query.Sources, source)
+		}
+	}
+
+	var err error
+	if from := params.Get("from"); from != "" {
+		if date, parseErr := time.Parse("2006-01-02", from); parseErr == nil {
+			query.From = date
+		} else {
+			err = fmt.Errorf("invalid 'from' date %q, expected format YYYY-MM-DD", from)
+		}
+	}
+	if to := params.Get("to"); to != "" {
+		if date, parseErr := time.Parse("2006-01-02", to); parseErr == nil {
+			query.To = date
+		} else {
+			err = fmt.Errorf("invalid 'to' date %q, expected format YYYY-MM-DD", to)
+		}
+	}
+
+	return query, err
+}
+
 func (s *Server) recentHandler(w http.ResponseWriter, r *http.Request) {
+	topic, ok := requestTopic(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
 	// Fetch articles.
-	articles := s.DB.GetRecentArticles()
+	articles, err := s.DB.GetRecentArticles(r.Context(), topic.Slug)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
 
 	// Fetch tasklog.
-	tasklog := s.DB.GetRecentTaskLog(TaskUpdateArticles)
+	tasklog, err := s.DB.GetRecentTaskLog(r.Context(), TaskUpdateArticles)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
 
 	// If there are no recent articles, then redirect to the index page.
 	if len(articles) == 0 {
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, topic.URL(""), http.StatusSeeOther)
+		return
 	}
 
 	// Prepare template data.
 	data := TemplateContext{
+		Topic:      topic,
 		Articles:   articles,
+		Query:      ArticleQuery{Topic: topic.Slug},
 		Pagination: false,
 		LastSync:   tasklog.CompletedAtDisplay(),
 		Version:    Version,
@@ -133,10 +280,15 @@ func (s *Server) recentHandler(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) aboutHandler(w http.ResponseWriter, r *http.Request) {
 	// Fetch tasklog.
-	tasklog := s.DB.GetRecentTaskLog(TaskUpdateArticles)
+	tasklog, err := s.DB.GetRecentTaskLog(r.Context(), TaskUpdateArticles)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
 
 	// Prepare template data.
 	data := TemplateContext{
+		Topic:    GetDefaultTopic(),
 		LastSync: tasklog.CompletedAtDisplay(),
 		Version:  Version,
 	}
@@ -146,10 +298,15 @@ func (s *Server) aboutHandler(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) resourcesHandler(w http.ResponseWriter, r *http.Request) {
 	// Fetch tasklog.
-	tasklog := s.DB.GetRecentTaskLog(TaskUpdateArticles)
+	tasklog, err := s.DB.GetRecentTaskLog(r.Context(), TaskUpdateArticles)
+	if err != nil {
+		s.serverError(w, r, err)
+		return
+	}
 
 	// Prepare the template data.
 	data := TemplateContext{
+		Topic:    GetDefaultTopic(),
 		LastSync: tasklog.CompletedAtDisplay(),
 		Version:  Version,
 	}
@@ -157,9 +314,103 @@ func (s *Server) resourcesHandler(w http.ResponseWriter, r *http.Request) {
 	s.Templates.ExecuteTemplate(w, "resources", data)
 }
 
+func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	// Fetch tasklog.
+	// The status page is rendered even when the database is down,
+	// so that the failure is reported by the database check.
+	tasklog, err := s.DB.GetRecentTaskLog(r.Context(), TaskUpdateArticles)
+	if err != nil {
+		tasklog = &TaskLog{}
+	}
+
+	// Prepare the template data.
+	data := TemplateContext{
+		Topic:        GetDefaultTopic(),
+		LastSync:     tasklog.CompletedAtDisplay(),
+		Version:      Version,
+		StatusChecks: RunChecks(r.Context(), s.StatusChecks()),
+	}
+
+	s.Templates.ExecuteTemplate(w, "status", data)
+}
+
+// serverError renders the error page for an error which is not the client's fault.
+func (s *Server) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	logRequestError(r, err)
+
+	data := TemplateContext{
+		Topic:   GetDefaultTopic(),
+		Version: Version,
+	}
+
+	w.WriteHeader(http.StatusInternalServerError)
+	s.Templates.ExecuteTemplate(w, "error", data)
+}
+
+// requestTopic returns the topic of the '/t/{topic}' page. Pages outside
+// of a topic are of the default topic. False is returned for unknown topics.
+func requestTopic(r *http.Request) (*Topic, bool) {
+	slug, ok := mux.Vars(r)["topic"]
+	if !ok {
+		return GetDefaultTopic(), true
+	}
+	return GetTopic(slug)
+}
+
+// errUnknownTopic is returned when the requested topic is not configured.
+var errUnknownTopic = errors.New("unknown topic")
+
+// logRequestError logs an error which occurred while handling the request.
+// Requests which were cancelled by the client are not errors.
+func logRequestError(r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		fmt.Printf("[request] %v %v cancelled\n", r.Method, r.RequestURI)
+		return
+	}
+	fmt.Printf("[error] %v %v: %v\n", r.Method, r.RequestURI, err)
+}
+
+// healthzHandler reports that the process is alive.
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports whether the server is ready to receive traffic.
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	results := RunChecks(r.Context(), s.ReadinessChecks())
+
+	status, statusCode := "ok", http.StatusOK
+	if !checksPassed(results) {
+		status, statusCode = "unavailable", http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, statusCode, map[string]interface{}{
+		"status": status,
+		"checks": results,
+	})
+}
+
+// writeJSON writes the value as a JSON response.
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
+
+// unloggedPaths are requested frequently by load balancers, and are not logged.
+var unloggedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
 // Middleware used to log the request.
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unloggedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
 		fmt.Printf("%v - %v %v\n", time.Now().Format(time.RFC822Z), r.Method, r.RequestURI)
 		next.ServeHTTP(w, r)
 	})
@@ -181,9 +432,35 @@ func (s *Server) GetRouter() *mux.Router {
 	router.HandleFunc("/recent", s.recentHandler).Methods("GET")
 	router.HandleFunc("/about", s.aboutHandler).Methods("GET")
 	router.HandleFunc("/resources", s.resourcesHandler).Methods("GET")
+	router.HandleFunc("/status", s.statusHandler).Methods("GET")
+	router.HandleFunc("/healthz", s.healthzHandler).Methods("GET")
+	router.HandleFunc("/readyz", s.readyzHandler).Methods("GET")
+	router.HandleFunc("/feed.rss", s.rssFeedHandler).Methods("GET")
+	router.HandleFunc("/feed.atom", s.atomFeedHandler).Methods("GET")
+	router.HandleFunc("/feed.json", s.jsonFeedHandler).Methods("GET")
+	router.HandleFunc("/t/{topic}", s.indexHandler).Methods("GET")
+	router.HandleFunc("/t/{topic}/recent", s.recentHandler).Methods("GET")
+	router.HandleFunc("/t/{topic}/feed.rss", s.rssFeedHandler).Methods("GET")
+	router.HandleFunc("/t/{topic}/feed.atom", s.atomFeedHandler).Methods("GET")
+	router.HandleFunc("/t/{topic}/feed.json", s.jsonFeedHandler).Methods("GET")
+	router.HandleFunc("/api/v1/articles", s.apiArticlesHandler).Methods("GET")
+	router.HandleFunc("/api/v1/articles/recent", s.apiRecentArticlesHandler).Methods("GET")
+	router.HandleFunc("/login", s.loginFormHandler).Methods("GET")
+	router.HandleFunc("/login", s.loginHandler).Methods("POST")
+	router.HandleFunc("/logout", s.logoutHandler).Methods("POST")
+	router.HandleFunc("/logout/everywhere", s.logoutEverywhereHandler).Methods("POST")
+	router.HandleFunc("/admin", s.adminOnly(http.HandlerFunc(s.adminIndexHandler)).ServeHTTP).Methods("GET")
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.HandleFunc("/jobs", s.adminJobsHandler).Methods("GET")
+	admin.HandleFunc("/fetch", s.adminFetchHandler).Methods("GET")
+	admin.HandleFunc("/fetch", s.adminStartFetchHandler).Methods("POST")
+	admin.HandleFunc("/fetch/{id:[0-9]+}", s.adminFetchRunHandler).Methods("GET")
+	admin.HandleFunc("/fetch/{id:[0-9]+}.json", s.adminFetchRunJSONHandler).Methods("GET")
+	admin.Use(s.adminOnly)
 	router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 	router.Use(loggingMiddleware)
 	router.Use(cookieMiddleWare)
+	router.Use(s.csrfProtect)
 	return router
 
 	// addr := fmt.Sprintf("0.0.0.0:%v", port)
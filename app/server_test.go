@@ -1,6 +1,10 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -27,6 +31,8 @@ func newTestServer(db Database) *Server {
 	s := &Server{}
 	s.Templates = s.GetTemplates()
 	s.DB = db
+	s.Sessions = NewSessionStore("test-session-secret-of-32-characters")
+	s.Logins = NewLoginLimiter()
 	return s
 }
 
@@ -44,8 +50,8 @@ func TestAboutHandler(t *testing.T) {
 	is := is.New(t)
 
 	mockDB := &MockServerDB{
-		getRecentTaskLogMock: func(task string) *TaskLog {
-			return &TaskLog{}
+		getRecentTaskLogMock: func(ctx context.Context, task string) (*TaskLog, error) {
+			return &TaskLog{}, nil
 		},
 	}
 
@@ -62,8 +68,8 @@ func TestResourcesHandler(t *testing.T) {
 	is := is.New(t)
 
 	mockDB := &MockServerDB{
-		getRecentTaskLogMock: func(task string) *TaskLog {
-			return &TaskLog{}
+		getRecentTaskLogMock: func(ctx context.Context, task string) (*TaskLog, error) {
+			return &TaskLog{}, nil
 		},
 	}
 
@@ -82,12 +88,12 @@ func TestRecentHandler(t *testing.T) {
 	pubDate := time.Now().AddDate(0, 0, -1)
 
 	mockDB := &MockServerDB{
-		getRecentTaskLogMock: func(task string) *TaskLog { return &TaskLog{} },
-		getRecentArticlesMock: func() []*Article {
+		getRecentTaskLogMock: func(ctx context.Context, task string) (*TaskLog, error) { return &TaskLog{}, nil },
+		getRecentArticlesMock: func(ctx context.Context, topic string) ([]*Article, error) {
 			return []*Article{
 				{ID: 1, Title: "Article 1", PublishedAt: pubDate},
 				{ID: 2, Title: "Article 2", PublishedAt: pubDate},
-			}
+			}, nil
 		},
 	}
 
@@ -108,9 +114,9 @@ func TestRecentHandler_NoRecentArticles(t *testing.T) {
 	is := is.New(t)
 
 	mockDB := &MockServerDB{
-		getRecentTaskLogMock: func(task string) *TaskLog { return &TaskLog{} },
-		getRecentArticlesMock: func() []*Article {
-			return []*Article{}
+		getRecentTaskLogMock: func(ctx context.Context, task string) (*TaskLog, error) { return &TaskLog{}, nil },
+		getRecentArticlesMock: func(ctx context.Context, topic string) ([]*Article, error) {
+			return []*Article{}, nil
 		},
 	}
 
@@ -121,25 +127,29 @@ func TestRecentHandler_NoRecentArticles(t *testing.T) {
 	// When there are no recent articles, then
 	// the handler should redirect to "/".
 	http.HandlerFunc(s.recentHandler).ServeHTTP(w, r)
-	doc := goqueryDoc(w.Body)
 
-	is.Equal(w.Code, http.StatusSeeOther) // Status code
+	is.Equal(w.Code, http.StatusSeeOther)     // Status code
+	is.Equal(w.Header().Get("Location"), "/") // Redirected to the index page
 
-	noResults := doc.Find("div").HasClass("app-no-results")
-	is.True(noResults) // No results div
+	noResults := goqueryDoc(w.Body).Find("div").HasClass("app-no-results")
+	is.True(!noResults) // The page is not rendered after the redirect
 }
 
 func TestIndexHandler(t *testing.T) {
 	is := is.New(t)
 
 	mockDB := &MockServerDB{
-		getRecentTaskLogMock: func(task string) *TaskLog { return &TaskLog{} },
-		getArticlesMock: func(q, pubDate string) ([]*Article, bool) {
-			return []*Article{
-				{ID: 1, Title: "Article 1"},
-				{ID: 2, Title: "Article 2"},
-				{ID: 3, Title: "Article 3"},
-			}, true
+		getRecentTaskLogMock:  func(ctx context.Context, task string) (*TaskLog, error) { return &TaskLog{}, nil },
+		getArticleSourcesMock: func(ctx context.Context) ([]string, error) { return []string{"cnn"}, nil },
+		getArticlesMock: func(ctx context.Context, query ArticleQuery) (*ArticlePage, error) {
+			return &ArticlePage{
+				Articles: []*Article{
+					{ID: 1, Title: "Article 1"},
+					{ID: 2, Title: "Article 2"},
+					{ID: 3, Title: "Article 3"},
+				},
+				HasMore: true,
+			}, nil
 		},
 	}
 
@@ -156,17 +166,58 @@ func TestIndexHandler(t *testing.T) {
 	is.Equal(articles, 3) // Three articles rendered
 }
 
+func TestIndexHandler_GroupsStories(t *testing.T) {
+	is := is.New(t)
+
+	gotQuery := ArticleQuery{}
+	mockDB := &MockServerDB{
+		getRecentTaskLogMock:  func(ctx context.Context, task string) (*TaskLog, error) { return &TaskLog{}, nil },
+		getArticleSourcesMock: func(ctx context.Context) ([]string, error) { return []string{"cnn"}, nil },
+		getArticlesMock: func(ctx context.Context, query ArticleQuery) (*ArticlePage, error) {
+			gotQuery = query
+			return &ArticlePage{
+				Articles: []*Article{
+					{ID: 1, Title: "Supreme Court blocks Trump from ending DACA", Source: "abc-news", Duplicates: []*Article{
+						{ID: 3, Title: "Supreme Court blocks Trump from ending DACA - CBS News", Source: "cbs-news"},
+					}},
+					{ID: 2, Title: "Judge orders DACA applications to be accepted", Source: "cnn"},
+				},
+			}, nil
+		},
+	}
+
+	s := newTestServer(mockDB)
+	r := httptest.NewRequest("GET", "/test", nil)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.indexHandler).ServeHTTP(w, r)
+	doc := goqueryDoc(w.Body)
+
+	is.Equal(w.Code, http.StatusOK) // Status code
+	is.True(gotQuery.Stories)       // The stories are grouped by the database
+
+	articles := doc.Find("div.app-article").Length()
+	is.Equal(articles, 2) // The copy of the story is grouped
+
+	duplicates := doc.Find("div.app-article-duplicates a")
+	is.Equal(duplicates.Length(), 1)
+	is.Equal(duplicates.Text(), "cbs-news")
+}
+
 func TestIndexHandler_PartialPage(t *testing.T) {
 	is := is.New(t)
 
 	mockDB := &MockServerDB{
-		getRecentTaskLogMock: func(task string) *TaskLog { return &TaskLog{} },
-		getArticlesMock: func(q, pubDate string) ([]*Article, bool) {
-			return []*Article{
-				{ID: 1, Title: "Article 1"},
-				{ID: 2, Title: "Article 2"},
-				{ID: 3, Title: "Article 3"},
-			}, true
+		getRecentTaskLogMock: func(ctx context.Context, task string) (*TaskLog, error) { return &TaskLog{}, nil },
+		getArticlesMock: func(ctx context.Context, query ArticleQuery) (*ArticlePage, error) {
+			return &ArticlePage{
+				Articles: []*Article{
+					{ID: 1, Title: "Article 1"},
+					{ID: 2, Title: "Article 2"},
+					{ID: 3, Title: "Article 3"},
+				},
+				HasMore: true,
+			}, nil
 		},
 	}
 
@@ -189,3 +240,184 @@ func TestIndexHandler_PartialPage(t *testing.T) {
 	articlesContainer := doc.Find("#articles").Length()
 	is.Equal(articlesContainer, 0) // No articles container
 }
+
+func TestJobsHandler(t *testing.T) {
+	is := is.New(t)
+	setTestJobs(t, &Job{Name: "fetch-articles", Description: "Fetch", Schedule: "@midnight", Timeout: time.Hour, LookbackDays: 3})
+
+	mockDB := &MockServerDB{
+		getRecentTaskLogMock: func(ctx context.Context, task string) (*TaskLog, error) { return &TaskLog{}, nil },
+	}
+
+	s := newTestServer(mockDB)
+	r := httptest.NewRequest("GET", "/admin/jobs", nil)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.adminJobsHandler).ServeHTTP(w, r)
+	doc := goqueryDoc(w.Body)
+
+	is.Equal(w.Code, http.StatusOK) // Status code
+
+	job := doc.Find(`[data-job="fetch-articles"]`)
+	is.Equal(job.Length(), 1)
+	is.Equal(job.Find(`[data-field="prev-run"]`).Text(), "-") // The job has not run
+	is.Equal(job.Find(`[data-field="timeout"]`).Text(), "1h0m0s")
+	is.True(job.Find(`[data-field="next-run"]`).Text() != "-") // The next run is computed from the schedule
+}
+
+func TestStatusHandler(t *testing.T) {
+	is := is.New(t)
+	os.Setenv("NEWS_API_KEY", "test")
+	defer os.Unsetenv("NEWS_API_KEY")
+
+	mockDB := &MockServerDB{
+		checkHealthMock: func(ctx context.Context) error { return nil },
+		getRecentTaskLogMock: func(ctx context.Context, task string) (*TaskLog, error) {
+			return &TaskLog{CompletedAt: time.Now().Add(-3 * 24 * time.Hour)}, nil
+		},
+		countArticlesMock: func(ctx context.Context, since time.Time) (int, error) { return 4, nil },
+	}
+
+	s := newTestServer(mockDB)
+	r := httptest.NewRequest("GET", "/test", nil)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.statusHandler).ServeHTTP(w, r)
+	doc := goqueryDoc(w.Body)
+
+	is.Equal(w.Code, http.StatusOK) // Status code
+
+	status := func(name string) string {
+		return doc.Find(fmt.Sprintf(`[data-status="%v"]`, name)).Text()
+	}
+	is.Equal(status("database"), "OK")
+	is.Equal(status("last-sync"), "Warning") // Last sync is stale
+	is.Equal(status("news-api-key"), "OK")
+	is.Equal(status("recent-articles"), "OK")
+}
+
+func TestHealthzHandler(t *testing.T) {
+	is := is.New(t)
+
+	s := newTestServer(&MockServerDB{})
+	r := httptest.NewRequest("GET", "/healthz", nil)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.healthzHandler).ServeHTTP(w, r)
+
+	is.Equal(w.Code, http.StatusOK) // Status code
+	is.Equal(w.Header().Get("Content-Type"), "application/json")
+}
+
+func TestReadyzHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		dbErr      error
+		lastSync   time.Time
+		advisory   bool
+		statusCode int
+	}{
+		{"ready", nil, time.Now(), false, http.StatusOK},
+		{"database unreachable", errors.New("database is locked"), time.Now(), false, http.StatusServiceUnavailable},
+		{"stale sync", nil, time.Now().Add(-3 * 24 * time.Hour), false, http.StatusServiceUnavailable},
+		{"stale sync, advisory", nil, time.Now().Add(-3 * 24 * time.Hour), true, http.StatusOK},
+		{"never synced, advisory", nil, time.Time{}, true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			mockDB := &MockServerDB{
+				checkHealthMock: func(ctx context.Context) error { return tt.dbErr },
+				getRecentTaskLogMock: func(ctx context.Context, task string) (*TaskLog, error) {
+					return &TaskLog{CompletedAt: tt.lastSync}, nil
+				},
+			}
+
+			s := newTestServer(mockDB)
+			s.StaleSyncAdvisory = tt.advisory
+			r := httptest.NewRequest("GET", "/readyz", nil)
+			w := httptest.NewRecorder()
+
+			http.HandlerFunc(s.readyzHandler).ServeHTTP(w, r)
+
+			is.Equal(w.Code, tt.statusCode) // Status code
+
+			body := struct {
+				Status string        `json:"status"`
+				Checks []StatusCheck `json:"checks"`
+			}{}
+			is.NoErr(json.NewDecoder(w.Body).Decode(&body))
+			is.Equal(len(body.Checks), 3) // Per-check results
+
+			if tt.dbErr != nil {
+				is.Equal(body.Checks[0].Info, tt.dbErr.Error()) // Database error is reported
+			}
+			if time.Since(tt.lastSync) > StaleSyncThreshold {
+				is.Equal(body.Checks[2].Status, "Warning")     // Stale sync is reported
+				is.Equal(body.Checks[2].Advisory, tt.advisory) // and fails readiness, unless advisory
+			}
+		})
+	}
+}
+
+func TestIndexHandler_Filters(t *testing.T) {
+	is := is.New(t)
+
+	var gotQuery ArticleQuery
+	mockDB := &MockServerDB{
+		getRecentTaskLogMock:  func(ctx context.Context, task string) (*TaskLog, error) { return &TaskLog{}, nil },
+		getArticleSourcesMock: func(ctx context.Context) ([]string, error) { return []string{"abc-news", "cnn", "fox-news"}, nil },
+		getArticlesMock: func(ctx context.Context, query ArticleQuery) (*ArticlePage, error) {
+			gotQuery = query
+			return &ArticlePage{Articles: []*Article{{ID: 1, Title: "Article 1"}}}, nil
+		},
+	}
+
+	s := newTestServer(mockDB)
+	r := httptest.NewRequest("GET", "/?q=daca&source=cnn&source=fox-news&from=2020-06-01&to=2020-06-30&author=Jane&before=2020-06-15+00:00:00", nil)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.indexHandler).ServeHTTP(w, r)
+	doc := goqueryDoc(w.Body)
+
+	is.Equal(w.Code, http.StatusOK) // Status code
+
+	// Filters are parsed from the query params, and composed with the cursor.
+	is.Equal(gotQuery.Text, "daca")
+	is.Equal(gotQuery.Sources, []string{"cnn", "fox-news"})
+	is.Equal(gotQuery.From, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	is.Equal(gotQuery.To, time.Date(2020, 6, 30, 0, 0, 0, 0, time.UTC))
+	is.Equal(gotQuery.Author, "Jane")
+	is.Equal(gotQuery.Before, "2020-06-15 00:00:00")
+
+	// The filters are rendered in the search form, so they can be shared.
+	is.Equal(doc.Find(`select[name="source"] option[selected]`).Length(), 2)
+	is.Equal(doc.Find(`input[name="from"]`).AttrOr("value", ""), "2020-06-01")
+	is.Equal(doc.Find(`input[name="author"]`).AttrOr("value", ""), "Jane")
+}
+
+func TestIndexHandler_DatabaseError(t *testing.T) {
+	is := is.New(t)
+
+	// The database returns the error of the request context.
+	mockDB := &MockServerDB{
+		getArticlesMock: func(ctx context.Context, query ArticleQuery) (*ArticlePage, error) {
+			return nil, ctx.Err()
+		},
+	}
+
+	s := newTestServer(mockDB)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest("GET", "/test", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.indexHandler).ServeHTTP(w, r)
+	doc := goqueryDoc(w.Body)
+
+	is.Equal(w.Code, http.StatusInternalServerError)  // Status code
+	is.Equal(doc.Find(".app-error").Length(), 1)      // Error page rendered
+	is.Equal(doc.Find(".app-no-results").Length(), 0) // Not mistaken for no results
+}
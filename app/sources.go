@@ -0,0 +1,126 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Source is a provider of news articles.
+type Source interface {
+	// Name identifies the source in logs and task records.
+	Name() string
+
+	// GetArticles fetches articles matching the query, published within the date range.
+	// The fetch stops when the context is done, such as when the run times out.
+	GetArticles(ctx context.Context, q string, from, to time.Time) ([]*Article, error)
+}
+
+// SourceFactory creates a Source. Sources are created lazily,
+// so that a misconfigured source is only noticed when it is used.
+type SourceFactory func() Source
+
+// sourceRegistry holds the factories of all the registered sources.
+var sourceRegistry = []SourceFactory{
+	func() Source { return NewNewsAPIClient() },
+	func() Source { return NewFeedClient() },
+}
+
+// RegisterSource adds a source to the registry.
+func RegisterSource(factory SourceFactory) {
+	sourceRegistry = append(sourceRegistry, factory)
+}
+
+// SetSources replaces the registered sources.
+func SetSources(factories ...SourceFactory) {
+	sourceRegistry = factories
+}
+
+// GetSources creates all the registered sources.
+func GetSources() []Source {
+	sources := []Source{}
+	for _, factory := range sourceRegistry {
+		sources = append(sources, factory())
+	}
+	return sources
+}
+
+// sourceNames lists the names of the registered sources, for validation and error messages.
+func sourceNames() []string {
+	names := []string{}
+	for _, source := range GetSources() {
+		names = append(names, source.Name())
+	}
+	return names
+}
+
+// SourceResult is the outcome of fetching articles from a single source.
+type SourceResult struct {
+	Source  string
+	Fetched int
+	Err     error
+
+	// Pages and Results are the pages which a paginated source read, and the
+	// total results which it reported. They are set with reportPages.
+	Pages   int
+	Results int
+}
+
+// sourceResultKey is the context key of the result of the source which is fetched.
+type sourceResultKey struct{}
+
+// reportPages records the pages read and the total results of a paginated source,
+// in the result of the source which is fetched with the context.
+func reportPages(ctx context.Context, pages, results int) {
+	if result, ok := ctx.Value(sourceResultKey{}).(*SourceResult); ok {
+		result.Pages = pages
+		result.Results = results
+	}
+}
+
+// Failed reports whether the source failed without fetching any articles.
+func (r SourceResult) Failed() bool {
+	return r.Err != nil && r.Fetched == 0
+}
+
+func (r SourceResult) String() string {
+	pages := ""
+	if r.Pages > 0 {
+		pages = fmt.Sprintf(" from %v pages, of %v results", r.Pages, r.Results)
+	}
+	if r.Err != nil && r.Fetched > 0 {
+		return fmt.Sprintf("%v: fetched %v articles%v, then failed (%v)", r.Source, r.Fetched, pages, r.Err)
+	}
+	if r.Err != nil {
+		return fmt.Sprintf("%v: failed (%v)", r.Source, r.Err)
+	}
+	return fmt.Sprintf("%v: fetched %v articles%v", r.Source, r.Fetched, pages)
+}
+
+// FetchFromSources fetches articles from each source, and merges the results.
+// Articles are deduplicated by URL. A failing source does not prevent the other
+// sources from being fetched; its error is recorded in the returned results.
+// The articles which a source fetched before it failed are kept.
+func FetchFromSources(ctx context.Context, sources []Source, q string, from, to time.Time) ([]*Article, []SourceResult) {
+	articles := []*Article{}
+	results := []SourceResult{}
+	seen := map[string]bool{}
+
+	for _, source := range sources {
+		result := SourceResult{Source: source.Name()}
+		fetched, err := source.GetArticles(context.WithValue(ctx, sourceResultKey{}, &result), q, from, to)
+		result.Fetched = len(fetched)
+		result.Err = err
+		results = append(results, result)
+
+		for _, article := range fetched {
+			if seen[article.URL] {
+				continue
+			}
+			seen[article.URL] = true
+			articles = append(articles, article)
+		}
+	}
+
+	return articles, results
+}
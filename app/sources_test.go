@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+// Test Helpers
+
+// fakeSource implements the Source interface.
+type fakeSource struct {
+	name     string
+	articles []*Article
+	err      error
+}
+
+func (f *fakeSource) Name() string {
+	return f.name
+}
+
+func (f *fakeSource) GetArticles(ctx context.Context, q string, from, to time.Time) ([]*Article, error) {
+	return f.articles, f.err
+}
+
+// ------------------------------------------------------------------
+
+func TestFetchFromSources(t *testing.T) {
+	is := is.New(t)
+
+	sources := []Source{
+		&fakeSource{name: "one", articles: []*Article{
+			{URL: "https://example.com/1"},
+			{URL: "https://example.com/2"},
+		}},
+		&fakeSource{name: "broken", err: errors.New("boom")},
+		&fakeSource{name: "two", articles: []*Article{
+			{URL: "https://example.com/2"},
+			{URL: "https://example.com/3"},
+		}},
+	}
+
+	articles, results := FetchFromSources(context.Background(), sources, "DACA", time.Now(), time.Now())
+
+	is.Equal(len(articles), 3)         // Articles are deduplicated by URL
+	is.Equal(len(results), 3)          // One result per source
+	is.Equal(results[0].Fetched, 2)    // First source result
+	is.True(results[1].Err != nil)     // Failing source is recorded
+	is.Equal(results[2].Source, "two") // Sources after a failure are still fetched
+}
+
+func TestFetchFromSources_PartialSource(t *testing.T) {
+	is := is.New(t)
+
+	sources := []Source{
+		&fakeSource{name: "partial", err: errors.New("boom"), articles: []*Article{
+			{URL: "https://example.com/1"},
+		}},
+		&fakeSource{name: "broken", err: errors.New("boom")},
+	}
+
+	articles, results := FetchFromSources(context.Background(), sources, "DACA", time.Now(), time.Now())
+
+	is.Equal(len(articles), 1)    // Articles fetched before the failure are kept
+	is.True(!results[0].Failed()) // A source with articles has not failed
+	is.True(results[1].Failed())  // A source without articles has failed
+
+	run := UpdateResult{Sources: results}
+	is.Equal(run.Status(), TaskStatusPartial) // The run is partial, not failed
+}
+
+func TestUpdateResult_StatusWithoutSources(t *testing.T) {
+	is := is.New(t)
+
+	run := UpdateResult{Saved: &InsertResult{}}
+	is.Equal(run.Status(), TaskStatusFailed) // A run without sources fetched nothing
+}
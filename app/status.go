@@ -0,0 +1,122 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StaleSyncThreshold is how long since the last successful
+// sync before the articles are considered out of date.
+const StaleSyncThreshold = 48 * time.Hour
+
+// Check is a named health check of the application.
+// An advisory check is reported, but its failure does not make the server unready.
+type Check struct {
+	Name     string
+	Run      func(ctx context.Context) (ok bool, info string)
+	Advisory bool
+}
+
+// StatusCheck is the result of running a Check.
+type StatusCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Status string `json:"status"`
+	Info   string `json:"info,omitempty"`
+
+	Advisory bool `json:"advisory,omitempty"`
+}
+
+// RunChecks runs each check, and collects the results.
+func RunChecks(ctx context.Context, checks []Check) []StatusCheck {
+	results := []StatusCheck{}
+	for _, check := range checks {
+		ok, info := check.Run(ctx)
+		status := "OK"
+		if !ok {
+			status = "Warning"
+		}
+		results = append(results, StatusCheck{Name: check.Name, OK: ok, Status: status, Info: info, Advisory: check.Advisory})
+	}
+	return results
+}
+
+// StatusChecks are the checks displayed on the status page.
+func (s *Server) StatusChecks() []Check {
+	return []Check{
+		{Name: "Database", Run: s.checkDatabase},
+		{Name: "Last Sync", Run: s.checkLastSync},
+		{Name: "News API Key", Run: checkNewsAPIKey},
+		{Name: "Recent Articles", Run: s.checkRecentArticles},
+	}
+}
+
+// ReadinessChecks must pass before the server can receive traffic.
+// A stale last sync fails readiness, unless the server's StaleSyncAdvisory
+// is set, which reports it as a warning.
+func (s *Server) ReadinessChecks() []Check {
+	return []Check{
+		{Name: "Database", Run: s.checkDatabase},
+		{Name: "Templates", Run: s.checkTemplates},
+		{Name: "Last Sync", Run: s.checkLastSync, Advisory: s.StaleSyncAdvisory},
+	}
+}
+
+// checksPassed reports whether all the check results, except
+// the advisory ones, are OK.
+func checksPassed(results []StatusCheck) bool {
+	for _, result := range results {
+		if !result.OK && !result.Advisory {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) checkDatabase(ctx context.Context) (bool, string) {
+	if err := s.DB.CheckHealth(ctx); err != nil {
+		return false, err.Error()
+	}
+	return true, ""
+}
+
+func (s *Server) checkTemplates(ctx context.Context) (bool, string) {
+	if s.Templates == nil || s.Templates.Lookup("index") == nil {
+		return false, "templates not loaded"
+	}
+	return true, ""
+}
+
+func (s *Server) checkLastSync(ctx context.Context) (bool, string) {
+	tasklog, err := s.DB.GetRecentTaskLog(ctx, TaskUpdateArticles)
+	if err != nil {
+		return false, err.Error()
+	}
+	if tasklog.CompletedAt.IsZero() {
+		return false, "never synced"
+	}
+
+	since := time.Since(tasklog.CompletedAt)
+	info := fmt.Sprintf("%v ago", since.Round(time.Minute))
+	return since <= StaleSyncThreshold, info
+}
+
+func checkNewsAPIKey(ctx context.Context) (bool, string) {
+	for _, source := range GetSources() {
+		if client, ok := source.(*NewsAPIClient); ok && client.APIKey == "" {
+			return false, "the NewsAPI key is not set"
+		}
+	}
+	return true, ""
+}
+
+func (s *Server) checkRecentArticles(ctx context.Context) (bool, string) {
+	since := time.Now().UTC().AddDate(0, 0, -RecentArticleThreshold)
+	count, err := s.DB.CountArticles(ctx, since)
+	if err != nil {
+		return false, err.Error()
+	}
+	info := fmt.Sprintf("%v in the last %v days", count, RecentArticleThreshold)
+	return count > 0, info
+}
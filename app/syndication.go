@@ -0,0 +1,400 @@
+package app
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// FeedSize is the number of articles published in the feeds.
+const FeedSize int = 50
+
+// FeedMaxAge is how long feed readers may cache the feeds.
+const FeedMaxAge = 15 * time.Minute
+
+// feedInfo is the data shared by all the feed formats.
+type feedInfo struct {
+	ID          string
+	Title       string
+	Description string
+	Author      string
+	HomeURL     string
+	SelfURL     string
+	Updated     time.Time
+	Articles    []*Article
+}
+
+// articleGUID is the stable, unique id of an article across all feed formats.
+// It does not depend on the host, so that it does not change behind a proxy.
+func articleGUID(article *Article) string {
+	return fmt.Sprintf("urn:dacabot:article:%v", article.ID)
+}
+
+// feedID is the stable id of the feed of the topic and the search text, ex:
+// "urn:dacabot:feed:daca:work%20permit". Like articleGUID, it does not depend on the host.
+func feedID(topic, text string) string {
+	id := "urn:dacabot:feed:" + topic
+	if text != "" {
+		id += ":" + url.PathEscape(text)
+	}
+	return id
+}
+
+// imageTypes are the media types of the lede image extensions.
+var imageTypes = map[string]string{
+	".gif":  "image/gif",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".png":  "image/png",
+	".svg":  "image/svg+xml",
+	".webp": "image/webp",
+}
+
+// imageType returns the media type of the image, from the extension of its url.
+// An empty string is returned when the type is unknown.
+func imageType(imageURL string) string {
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return ""
+	}
+	return imageTypes[strings.ToLower(path.Ext(u.Path))]
+}
+
+// getFeedInfo fetches the articles for a feed of the topic, honoring the search text and filters.
+func (s *Server) getFeedInfo(r *http.Request) (*feedInfo, error) {
+	topic, ok := requestTopic(r)
+	if !ok {
+		return nil, errUnknownTopic
+	}
+
+	query, _ := articleQueryParams(r)
+	if validateCursor(query) != nil {
+		query.Before = ""
+	}
+	query.Topic = topic.Slug
+	articles, err := s.DB.GetLatestArticles(r.Context(), query, FeedSize)
+	if err != nil {
+		return nil, err
+	}
+
+	siteURL := requestBaseURL(r)
+	info := &feedInfo{
+		ID:          feedID(topic.Slug, query.Text),
+		Title:       topic.SiteName(),
+		Description: topic.Description,
+		Author:      topic.SiteName(),
+		HomeURL:     siteURL + topic.URL(""),
+		SelfURL:     siteURL + r.URL.RequestURI(),
+		Articles:    articles,
+	}
+
+	if query.Text != "" {
+		info.Title = fmt.Sprintf("%v - %v", topic.SiteName(), query.Text)
+	}
+	if query.Text != "" || query.HasFilters() {
+		query.Sort = ""
+		info.HomeURL = siteURL + query.Path() + "?" + query.Params().Encode()
+	}
+
+	// The feed is updated whenever an article is added.
+	for _, article := range articles {
+		if article.CreatedAt.After(info.Updated) {
+			info.Updated = article.CreatedAt
+		}
+	}
+	if info.Updated.IsZero() {
+		info.Updated = time.Now()
+	}
+	info.Updated = info.Updated.UTC().Truncate(time.Second)
+
+	return info, nil
+}
+
+// feedError writes the error response of a feed.
+func feedError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errUnknownTopic) {
+		http.NotFound(w, r)
+		return
+	}
+	logRequestError(r, err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// requestBaseURL is the scheme and host the request was made to.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%v://%v", scheme, r.Host)
+}
+
+// writeFeed writes the feed with caching headers. A '304 Not Modified'
+// is sent if the feed has not been updated since the client last fetched it.
+func writeFeed(w http.ResponseWriter, r *http.Request, info *feedInfo, contentType string, body []byte) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%v", int(FeedMaxAge.Seconds())))
+	w.Header().Set("Last-Modified", info.Updated.Format(http.TimeFormat))
+
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !info.Updated.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+// ------------------------------------------------------------------
+// RSS 2.0
+// ------------------------------------------------------------------
+
+type rssOutput struct {
+	XMLName xml.Name         `xml:"rss"`
+	Version string           `xml:"version,attr"`
+	Atom    string           `xml:"xmlns:atom,attr"`
+	DC      string           `xml:"xmlns:dc,attr"`
+	Channel rssOutputChannel `xml:"channel"`
+}
+
+type rssOutputChannel struct {
+	Title         string          `xml:"title"`
+	Link          string          `xml:"link"`
+	Self          atomOutputLink  `xml:"atom:link"`
+	Description   string          `xml:"description"`
+	LastBuildDate string          `xml:"lastBuildDate"`
+	Items         []rssOutputItem `xml:"item"`
+}
+
+type rssOutputItem struct {
+	Title       string          `xml:"title"`
+	Link        string          `xml:"link"`
+	Description string          `xml:"description,omitempty"`
+	Author      string          `xml:"dc:creator,omitempty"`
+	Source      string          `xml:"category,omitempty"`
+	GUID        rssOutputGUID   `xml:"guid"`
+	PubDate     string          `xml:"pubDate"`
+	Enclosure   *rssOutputMedia `xml:"enclosure,omitempty"`
+}
+
+type rssOutputGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssOutputMedia struct {
+	URL    string `xml:"url,attr"`
+	Length int    `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func (s *Server) rssFeedHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := s.getFeedInfo(r)
+	if err != nil {
+		feedError(w, r, err)
+		return
+	}
+
+	feed := rssOutput{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssOutputChannel{
+			Title:         info.Title,
+			Link:          info.HomeURL,
+			Self:          atomOutputLink{Href: info.SelfURL, Rel: "self", Type: "application/rss+xml"},
+			Description:   info.Description,
+			LastBuildDate: info.Updated.Format(time.RFC1123Z),
+		},
+	}
+
+	for _, article := range info.Articles {
+		item := rssOutputItem{
+			Title:       article.Title,
+			Link:        article.URL,
+			Description: article.Description,
+			Author:      article.Author,
+			Source:      article.Source,
+			GUID:        rssOutputGUID{Value: articleGUID(article)},
+			PubDate:     article.PublishedAt.UTC().Format(time.RFC1123Z),
+		}
+		// The size of the image is not known, and is given as zero.
+		if mediaType := imageType(article.LedeImg); mediaType != "" {
+			item.Enclosure = &rssOutputMedia{URL: article.LedeImg, Type: mediaType}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeFeed(w, r, info, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+// ------------------------------------------------------------------
+// Atom
+// ------------------------------------------------------------------
+
+type atomOutput struct {
+	XMLName xml.Name          `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string            `xml:"id"`
+	Title   string            `xml:"title"`
+	Updated string            `xml:"updated"`
+	Author  atomOutputName    `xml:"author"`
+	Links   []atomOutputLink  `xml:"link"`
+	Entries []atomOutputEntry `xml:"entry"`
+}
+
+type atomOutputLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomOutputEntry struct {
+	ID        string           `xml:"id"`
+	Title     string           `xml:"title"`
+	Links     []atomOutputLink `xml:"link"`
+	Summary   string           `xml:"summary,omitempty"`
+	Author    *atomOutputName  `xml:"author,omitempty"`
+	Category  *atomOutputTerm  `xml:"category,omitempty"`
+	Published string           `xml:"published"`
+	Updated   string           `xml:"updated"`
+}
+
+type atomOutputName struct {
+	Name string `xml:"name"`
+}
+
+type atomOutputTerm struct {
+	Term string `xml:"term,attr"`
+}
+
+func (s *Server) atomFeedHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := s.getFeedInfo(r)
+	if err != nil {
+		feedError(w, r, err)
+		return
+	}
+
+	// The feed has an author, as the entries without an author inherit it.
+	feed := atomOutput{
+		ID:      info.ID,
+		Title:   info.Title,
+		Updated: info.Updated.Format(time.RFC3339),
+		Author:  atomOutputName{Name: info.Author},
+		Links: []atomOutputLink{
+			{Href: info.HomeURL, Rel: "alternate", Type: "text/html"},
+			{Href: info.SelfURL, Rel: "self", Type: "application/atom+xml"},
+		},
+	}
+
+	for _, article := range info.Articles {
+		entry := atomOutputEntry{
+			ID:        articleGUID(article),
+			Title:     article.Title,
+			Links:     []atomOutputLink{{Href: article.URL, Rel: "alternate"}},
+			Summary:   article.Description,
+			Published: article.PublishedAt.UTC().Format(time.RFC3339),
+			Updated:   article.PublishedAt.UTC().Format(time.RFC3339),
+		}
+		if article.Author != "" {
+			entry.Author = &atomOutputName{Name: article.Author}
+		}
+		if article.Source != "" {
+			entry.Category = &atomOutputTerm{Term: article.Source}
+		}
+		if mediaType := imageType(article.LedeImg); mediaType != "" {
+			entry.Links = append(entry.Links, atomOutputLink{Href: article.LedeImg, Rel: "enclosure", Type: mediaType})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeFeed(w, r, info, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), body...))
+}
+
+// ------------------------------------------------------------------
+// JSON Feed
+// Ref: https://www.jsonfeed.org/version/1.1/
+// ------------------------------------------------------------------
+
+type jsonFeedOutput struct {
+	Version     string               `json:"version"`
+	Title       string               `json:"title"`
+	HomePageURL string               `json:"home_page_url"`
+	FeedURL     string               `json:"feed_url"`
+	Items       []jsonFeedOutputItem `json:"items"`
+}
+
+type jsonFeedOutputItem struct {
+	ID            string                 `json:"id"`
+	URL           string                 `json:"url"`
+	Title         string                 `json:"title"`
+	Summary       string                 `json:"summary,omitempty"`
+	ContentText   string                 `json:"content_text"`
+	Image         string                 `json:"image,omitempty"`
+	DatePublished string                 `json:"date_published"`
+	Authors       []jsonFeedOutputAuthor `json:"authors,omitempty"`
+	Tags          []string               `json:"tags,omitempty"`
+}
+
+type jsonFeedOutputAuthor struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) jsonFeedHandler(w http.ResponseWriter, r *http.Request) {
+	info, err := s.getFeedInfo(r)
+	if err != nil {
+		feedError(w, r, err)
+		return
+	}
+
+	feed := jsonFeedOutput{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       info.Title,
+		HomePageURL: info.HomeURL,
+		FeedURL:     info.SelfURL,
+		Items:       []jsonFeedOutputItem{},
+	}
+
+	for _, article := range info.Articles {
+		item := jsonFeedOutputItem{
+			ID:            articleGUID(article),
+			URL:           article.URL,
+			Title:         article.Title,
+			Summary:       article.Description,
+			ContentText:   article.Description,
+			Image:         article.LedeImg,
+			DatePublished: article.PublishedAt.UTC().Format(time.RFC3339),
+		}
+		if article.Author != "" {
+			item.Authors = []jsonFeedOutputAuthor{{Name: article.Author}}
+		}
+		if article.Source != "" {
+			item.Tags = []string{article.Source}
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	body, err := json.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	writeFeed(w, r, info, "application/feed+json; charset=utf-8", body)
+}
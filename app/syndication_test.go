@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+// Test Helpers
+
+func newFeedTestServer(gotQuery *string) *Server {
+	createdAt := time.Date(2020, 6, 26, 8, 0, 0, 0, time.UTC)
+	return newTestServer(&MockServerDB{
+		getLatestArticlesMock: func(ctx context.Context, query ArticleQuery, limit int) ([]*Article, error) {
+			*gotQuery = query.Text
+			return []*Article{
+				{ID: 2, URL: "https://example.com/2", Title: "Article 2", Author: "Jane", LedeImg: "https://example.com/2.PNG?w=600", PublishedAt: createdAt, CreatedAt: createdAt},
+				{ID: 1, URL: "https://example.com/1", Title: "Article 1", LedeImg: "https://example.com/image?id=1", PublishedAt: createdAt.Add(-time.Hour), CreatedAt: createdAt.Add(-time.Hour)},
+			}, nil
+		},
+	})
+}
+
+// ------------------------------------------------------------------
+
+func TestRSSFeedHandler(t *testing.T) {
+	is := is.New(t)
+
+	gotQuery := ""
+	s := newFeedTestServer(&gotQuery)
+	r := httptest.NewRequest("GET", "http://dacabot.test/feed.rss?q=citizenship", nil)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.rssFeedHandler).ServeHTTP(w, r)
+
+	is.Equal(w.Code, http.StatusOK)   // Status code
+	is.Equal(gotQuery, "citizenship") // Search filter is honored
+	is.Equal(w.Header().Get("Last-Modified"), "Fri, 26 Jun 2020 08:00:00 GMT")
+
+	feed := rssOutput{}
+	is.NoErr(xml.NewDecoder(w.Body).Decode(&feed))
+	is.Equal(len(feed.Channel.Items), 2)
+	is.Equal(feed.Channel.Items[0].GUID.Value, "urn:dacabot:article:2") // GUID from article id
+	is.Equal(feed.Channel.Items[0].PubDate, "Fri, 26 Jun 2020 08:00:00 +0000")
+	is.Equal(feed.Channel.Items[0].Enclosure.Type, "image/png") // Image type from the extension
+	is.True(feed.Channel.Items[1].Enclosure == nil)             // No enclosure for an unknown type
+}
+
+func TestAtomFeedHandler(t *testing.T) {
+	is := is.New(t)
+
+	gotQuery := ""
+	s := newFeedTestServer(&gotQuery)
+	r := httptest.NewRequest("GET", "http://dacabot.test/feed.atom", nil)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.atomFeedHandler).ServeHTTP(w, r)
+
+	is.Equal(w.Code, http.StatusOK) // Status code
+
+	feed := atomOutput{}
+	is.NoErr(xml.NewDecoder(w.Body).Decode(&feed))
+	is.Equal(feed.Updated, "2020-06-26T08:00:00Z") // Updated is the latest article
+	is.Equal(feed.ID, "urn:dacabot:feed:daca")     // The id does not depend on the host
+	is.True(feed.Author.Name != "")                // The entries without an author inherit it
+	is.Equal(len(feed.Entries), 2)
+	is.Equal(feed.Entries[0].ID, "urn:dacabot:article:2")
+	is.Equal(feed.Entries[0].Author.Name, "Jane")
+	is.Equal(feed.Entries[0].Links[1].Type, "image/png") // Enclosure type from the extension
+	is.Equal(len(feed.Entries[1].Links), 1)              // No enclosure for an unknown type
+}
+
+func TestFeedID(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(feedID("daca", ""), "urn:dacabot:feed:daca")
+	is.Equal(feedID("tps", "work permit"), "urn:dacabot:feed:tps:work%20permit")
+}
+
+func TestJSONFeedHandler(t *testing.T) {
+	is := is.New(t)
+
+	gotQuery := ""
+	s := newFeedTestServer(&gotQuery)
+	r := httptest.NewRequest("GET", "http://dacabot.test/feed.json", nil)
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.jsonFeedHandler).ServeHTTP(w, r)
+
+	is.Equal(w.Code, http.StatusOK) // Status code
+
+	feed := jsonFeedOutput{}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&feed))
+	is.Equal(feed.FeedURL, "http://dacabot.test/feed.json")
+	is.Equal(len(feed.Items), 2)
+	is.Equal(feed.Items[1].ID, "urn:dacabot:article:1")
+}
+
+func TestFeedHandler_NotModified(t *testing.T) {
+	is := is.New(t)
+
+	gotQuery := ""
+	s := newFeedTestServer(&gotQuery)
+	r := httptest.NewRequest("GET", "http://dacabot.test/feed.rss", nil)
+	r.Header.Set("If-Modified-Since", "Fri, 26 Jun 2020 08:00:00 GMT")
+	w := httptest.NewRecorder()
+
+	http.HandlerFunc(s.rssFeedHandler).ServeHTTP(w, r)
+
+	is.Equal(w.Code, http.StatusNotModified) // Feed has not changed
+	is.Equal(w.Body.Len(), 0)
+}
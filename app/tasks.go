@@ -1,47 +1,185 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"strings"
 	"time"
-
-	"github.com/robfig/cron/v3"
 )
 
 var TaskUpdateArticles string = "UpdateArticles"
 
-// SetupTasks creates and runs background tasks.
-// Ref: https://godoc.org/github.com/robfig/cron
-// CRON Ref: https://www.adminschoice.com/crontab-quick-reference
-func SetupTasks() {
-	fmt.Println("[setup] tasks")
-	c := cron.New()
-	c.AddFunc("@midnight", func() {
-		to := time.Now().UTC()
-		from := to.AddDate(0, 0, -3) // 3 days back.
-		UpdateArticles(from, to, false)
-	})
-	c.Start()
+// ErrAllSourcesFailed is returned when articles could not be fetched from any source.
+var ErrAllSourcesFailed = errors.New("all sources failed")
+
+// ErrTaskLogNotFound is returned when a tasklog does not exist.
+var ErrTaskLogNotFound = errors.New("tasklog not found")
+
+// UpdateResult is the outcome of an UpdateArticles run.
+type UpdateResult struct {
+	Sources    []SourceResult
+	Fetched    int
+	Saved      *InsertResult
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// TaskLogID is the id of the recorded run, or 0 when it was not recorded.
+	TaskLogID int
+
+	// Err is a failure to save the fetched articles.
+	Err error
 }
 
-// UpdateArticles fetches new articles from NewsAPI and saves it to the database.
-func UpdateArticles(from, to time.Time, manual bool) {
-	fmt.Println()
-	db := NewDB()
-	db.CreateTables()
+// Status of the run, derived from the source results.
+func (r *UpdateResult) Status() string {
+	failed, errored := 0, 0
+	for _, source := range r.Sources {
+		if source.Failed() {
+			failed++
+		}
+		if source.Err != nil {
+			errored++
+		}
+	}
+
+	switch {
+	case r.Err != nil:
+		return TaskStatusFailed
+	case failed == len(r.Sources):
+		// A run without sources fetched nothing.
+		return TaskStatusFailed
+	case errored > 0, r.Saved != nil && r.Saved.Failed() > 0:
+		return TaskStatusPartial
+	}
+	return TaskStatusSuccess
+}
 
-	searchTerm := "DACA"
+// TaskLog converts the result into a task run record.
+func (r *UpdateResult) TaskLog(manual bool) *TaskLog {
+	tasklog := &TaskLog{
+		Task:        TaskUpdateArticles,
+		Manual:      manual,
+		Status:      r.Status(),
+		StartedAt:   r.StartedAt,
+		CompletedAt: r.FinishedAt,
+		Fetched:     r.Fetched,
+	}
+	if r.Saved != nil {
+		tasklog.Inserted = r.Saved.Inserted()
+		tasklog.Updated = r.Saved.Updated
+		tasklog.Duplicates = r.Saved.Unchanged
+		tasklog.Failed = r.Saved.Failed()
+	}
+
+	stats := []TaskSourceStat{}
+	errs := []string{}
+	for _, source := range r.Sources {
+		stat := TaskSourceStat{Source: source.Source, Fetched: source.Fetched, Pages: source.Pages, Results: source.Results}
+		if source.Err != nil {
+			stat.Error = source.Err.Error()
+			errs = append(errs, fmt.Sprintf("%v: %v", source.Source, source.Err))
+		}
+		stats = append(stats, stat)
+	}
+	if r.Saved != nil && r.Saved.Failed() > 0 {
+		errs = append(errs, fmt.Sprintf("%v articles could not be saved: %v", r.Saved.Failed(), r.Saved.Errors[0]))
+	}
+	if r.Err != nil {
+		errs = append(errs, r.Err.Error())
+	}
+	tasklog.SetSourceStats(stats)
+	tasklog.Error = strings.Join(errs, "; ")
+
+	return tasklog
+}
 
-	fmt.Printf("[update-articles] %v, from %v, to %v\n", searchTerm, from.Format("2006-01-02"), to.Format("2006-01-02"))
+// UpdateArticles fetches new articles of the topic from its sources and saves them to the database.
+// Every run is recorded in the tasklog. An error is returned when none of the sources could be fetched,
+// or when the articles or the run could not be saved.
+//
+// Runs hold the lease of the task, so that runs from the scheduler, the command line and other
+// servers do not overlap. A run which finds the task locked is recorded as skipped, and returns
+// an error wrapping ErrTaskLocked.
+func UpdateArticles(ctx context.Context, db Database, topic *Topic, from, to time.Time, manual bool) (*UpdateResult, error) {
+	fmt.Println()
+	progressf(ctx, "[update-articles] %v (%v), from %v, to %v\n", topic.Slug, topic.Query, from.Format("2006-01-02"), to.Format("2006-01-02"))
 
-	articles, err := NewNewsAPIClient().GetArticles(searchTerm, from, to)
+	lease, err := AcquireTaskLease(ctx, db, TaskUpdateArticles, TaskLeaseTTL)
 	if err != nil {
-		log.Fatal(err)
+		if errors.Is(err, ErrTaskLocked) {
+			progressf(ctx, "[update-articles] skipped: %v\n", err)
+			skipped := &TaskLog{Task: TaskUpdateArticles, Manual: manual, Status: TaskStatusSkipped, Error: err.Error()}
+			if recordErr := db.RecordTask(ctx, skipped); recordErr != nil {
+				fmt.Printf("[update-articles] could not record the task: %v\n", recordErr)
+			}
+		}
+		return nil, err
+	}
+	defer ReleaseTaskLease(db, lease)
+
+	result := fetchArticles(ctx, db, topic.GetSources(), topic, from, to)
+
+	switch {
+	case result.Err != nil:
+		err = result.Err
+	case result.Status() == TaskStatusFailed:
+		err = ErrAllSourcesFailed
+	}
+
+	tasklog := result.TaskLog(manual)
+	if recordErr := db.RecordTask(ctx, tasklog); recordErr != nil && err == nil {
+		err = fmt.Errorf("could not record the task: %w", recordErr)
+	}
+	result.TaskLogID = tasklog.ID
+	return result, err
+}
+
+// fetchArticles fetches the articles of the topic from the sources and saves them to the database.
+// The articles are not saved when all the sources failed.
+func fetchArticles(ctx context.Context, db Database, sources []Source, topic *Topic, from, to time.Time) *UpdateResult {
+	result := &UpdateResult{StartedAt: time.Now().UTC()}
+
+	progressf(ctx, "[update-articles] fetching from %v sources\n", len(sources))
+	articles, results := FetchFromSources(ctx, sources, topic.Query, from, to)
+	for _, article := range articles {
+		article.Topic = topic.Slug
+	}
+	for _, sourceResult := range results {
+		progressf(ctx, "[update-articles] %v\n", sourceResult)
 	}
+	result.Sources = results
+	result.Fetched = len(articles)
 
-	fmt.Printf("Fetched %v articles\n", len(articles))
-	articleIDs := db.InsertArticles(articles)
-	fmt.Printf("Created %v new articles. IDs: %v\n", len(articleIDs), articleIDs)
+	if result.Status() != TaskStatusFailed {
+		progressf(ctx, "Fetched %v articles\n", len(articles))
+		result.Saved, result.Err = db.InsertArticles(ctx, articles)
+		if saved := result.Saved; saved != nil {
+			progressf(ctx, "Created %v new articles. IDs: %v\n", saved.Inserted(), saved.InsertedIDs)
+			progressf(ctx, "Updated %v articles, %v unchanged, %v failed\n", saved.Updated, saved.Unchanged, saved.Failed())
+			for _, saveErr := range saved.Errors {
+				fmt.Printf("[update-articles] %v\n", saveErr)
+			}
+		}
+	}
+
+	result.FinishedAt = time.Now().UTC()
+	return result
+}
 
-	db.RecordTask(TaskUpdateArticles, manual)
+// progressKey is the context key of the progress reporter of a task.
+type progressKey struct{}
+
+// WithProgress returns a context which reports the progress messages of the tasks run with it.
+func WithProgress(ctx context.Context, report func(message string)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// progressf prints a progress message of a task, and reports it to the reporter of the context.
+func progressf(ctx context.Context, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Print(message)
+	if report, ok := ctx.Value(progressKey{}).(func(string)); ok {
+		report(strings.TrimSpace(message))
+	}
 }
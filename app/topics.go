@@ -0,0 +1,148 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Topic is a subject which articles are fetched and served for. Each topic
+// is served at '/t/{slug}', and the default topic is also served at '/'.
+type Topic struct {
+	// Slug identifies the topic in urls and in the database.
+	Slug string `toml:"slug"`
+
+	// Name is the display name, ex: "DACA".
+	Name string `toml:"name"`
+
+	// Description is the tagline shown in the page header.
+	Description string `toml:"description"`
+
+	// Query is the search expression sent to the sources, ex: `"TPS" OR "temporary protected status"`.
+	Query string `toml:"query"`
+
+	// Sources are the names of the sources to fetch from. All the registered sources are used when empty.
+	Sources []string `toml:"sources"`
+
+	// Tags are the featured searches shown below the search box.
+	Tags []string `toml:"tags"`
+}
+
+// DefaultTopic is the topic of dacabot.
+var DefaultTopic = &Topic{
+	Slug:        "daca",
+	Name:        "DACA",
+	Description: "DACA news articles from around the web",
+	Query:       "DACA",
+	Tags:        []string{"Trump", "Dreamer", "citizenship", "COVID"},
+}
+
+// topicRegistry holds the configured topics. The first topic is the default topic.
+var topicRegistry = []*Topic{DefaultTopic}
+
+// topicSlugPattern matches the slugs which are valid url path segments.
+var topicSlugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// GetTopics returns the configured topics. The first topic is the default topic.
+func GetTopics() []*Topic {
+	return topicRegistry
+}
+
+// GetDefaultTopic returns the topic which is served at '/'.
+func GetDefaultTopic() *Topic {
+	return topicRegistry[0]
+}
+
+// GetTopic returns the topic with the slug.
+func GetTopic(slug string) (*Topic, bool) {
+	for _, topic := range topicRegistry {
+		if topic.Slug == slug {
+			return topic, true
+		}
+	}
+	return nil, false
+}
+
+// SetTopics replaces the configured topics. The first topic becomes the default topic.
+func SetTopics(topics []*Topic) error {
+	if len(topics) == 0 {
+		return fmt.Errorf("at least one topic is required")
+	}
+
+	seen := map[string]bool{}
+	for _, topic := range topics {
+		if err := topic.validate(); err != nil {
+			return err
+		}
+		if seen[topic.Slug] {
+			return fmt.Errorf("topic %q is configured twice", topic.Slug)
+		}
+		seen[topic.Slug] = true
+	}
+
+	topicRegistry = topics
+	return nil
+}
+
+func (t *Topic) validate() error {
+	if !topicSlugPattern.MatchString(t.Slug) {
+		return fmt.Errorf("invalid topic slug %q, use lowercase letters, numbers and dashes", t.Slug)
+	}
+	if strings.TrimSpace(t.Query) == "" {
+		return fmt.Errorf("topic %q has no query", t.Slug)
+	}
+	names := sourceNames()
+	for _, source := range t.Sources {
+		known := false
+		for _, name := range names {
+			known = known || name == source
+		}
+		if !known {
+			return fmt.Errorf("topic %q has unknown source %q, expected one of: %v", t.Slug, source, strings.Join(names, ", "))
+		}
+	}
+	if t.Name == "" {
+		t.Name = t.Slug
+	}
+	return nil
+}
+
+// IsDefault reports whether the topic is served at '/'.
+func (t *Topic) IsDefault() bool {
+	return t == GetDefaultTopic()
+}
+
+// URL links to a page of the topic, ex: URL("feed.rss").
+func (t *Topic) URL(path string) string {
+	base := "/t/" + t.Slug + "/"
+	if t.IsDefault() {
+		base = "/"
+	}
+	if path == "" && !t.IsDefault() {
+		return strings.TrimSuffix(base, "/")
+	}
+	return base + path
+}
+
+// SiteName is the name of the site of the topic, ex: "DACAbot".
+func (t *Topic) SiteName() string {
+	return t.Name + "bot"
+}
+
+// GetSources creates the registered sources which are enabled for the topic.
+func (t *Topic) GetSources() []Source {
+	sources := GetSources()
+	if len(t.Sources) == 0 {
+		return sources
+	}
+
+	enabled := []Source{}
+	for _, source := range sources {
+		for _, name := range t.Sources {
+			if source.Name() == name {
+				enabled = append(enabled, source)
+			}
+		}
+	}
+	return enabled
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+// ------------------------------------------------------------------
+// Test Helpers
+
+// tpsTopic is a second topic, used in tests.
+var tpsTopic = &Topic{
+	Slug:        "tps",
+	Name:        "TPS",
+	Description: "TPS news articles from around the web",
+	Query:       `"TPS" OR "temporary protected status"`,
+	Sources:     []string{"newsapi"},
+	Tags:        []string{"Haiti"},
+}
+
+// setTestTopics configures the topics for the test, and restores the topics after the test.
+func setTestTopics(t *testing.T, topics ...*Topic) {
+	previous := topicRegistry
+	if err := SetTopics(topics); err != nil {
+		t.Fatalf("could not set topics: %v", err)
+	}
+	t.Cleanup(func() { topicRegistry = previous })
+}
+
+// ------------------------------------------------------------------
+
+func TestSetTopics(t *testing.T) {
+	is := is.New(t)
+	setTestTopics(t, DefaultTopic)
+
+	is.True(SetTopics(nil) != nil)                                                   // A topic is required
+	is.True(SetTopics([]*Topic{{Slug: "H-1B", Query: "H-1B"}}) != nil)               // Slugs are lowercase
+	is.True(SetTopics([]*Topic{{Slug: "h1b"}}) != nil)                               // A query is required
+	is.True(SetTopics([]*Topic{DefaultTopic, {Slug: "daca", Query: "DACA"}}) != nil) // Slugs are unique
+	is.Equal(GetDefaultTopic(), DefaultTopic)                                        // Invalid topics are not set
+
+	err := SetTopics([]*Topic{{Slug: "h1b", Query: "H-1B", Sources: []string{"newsap"}}})
+	is.True(err != nil) // Sources must be registered
+	is.True(strings.Contains(err.Error(), "newsapi, feeds"))
+
+	is.NoErr(SetTopics([]*Topic{tpsTopic, DefaultTopic}))
+	is.Equal(GetDefaultTopic(), tpsTopic) // The first topic is the default topic
+	topic, ok := GetTopic("daca")
+	is.True(ok)
+	is.Equal(topic, DefaultTopic)
+	_, ok = GetTopic("asylum")
+	is.True(!ok)
+}
+
+func TestTopicURL(t *testing.T) {
+	is := is.New(t)
+	setTestTopics(t, DefaultTopic, tpsTopic)
+
+	is.Equal(DefaultTopic.URL(""), "/")
+	is.Equal(DefaultTopic.URL("feed.rss"), "/feed.rss")
+	is.Equal(tpsTopic.URL(""), "/t/tps")
+	is.Equal(tpsTopic.URL("feed.rss"), "/t/tps/feed.rss")
+	is.Equal(tpsTopic.SiteName(), "TPSbot")
+}
+
+func TestTopicGetSources(t *testing.T) {
+	is := is.New(t)
+
+	is.Equal(len(DefaultTopic.GetSources()), len(GetSources())) // All sources are used by default
+
+	sources := tpsTopic.GetSources()
+	is.Equal(len(sources), 1)
+	is.Equal(sources[0].Name(), "newsapi")
+}
+
+func TestTopicHandlers(t *testing.T) {
+	is := is.New(t)
+	setTestTopics(t, DefaultTopic, tpsTopic)
+
+	gotTopic := ""
+	s := newTestServer(&MockServerDB{
+		getRecentTaskLogMock:  func(ctx context.Context, task string) (*TaskLog, error) { return &TaskLog{}, nil },
+		getArticleSourcesMock: func(ctx context.Context) ([]string, error) { return []string{"cnn"}, nil },
+		getArticlesMock: func(ctx context.Context, query ArticleQuery) (*ArticlePage, error) {
+			gotTopic = query.Topic
+			return &ArticlePage{Articles: []*Article{{ID: 1, Title: "Article 1", Source: "cnn"}}}, nil
+		},
+		getLatestArticlesMock: func(ctx context.Context, query ArticleQuery, limit int) ([]*Article, error) {
+			gotTopic = query.Topic
+			return []*Article{}, nil
+		},
+	})
+	router := s.GetRouter()
+
+	// The default topic is served at '/'.
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(gotTopic, "daca")
+	doc := goqueryDoc(w.Body)
+	is.Equal(doc.Find("header h1 a").Text(), "DACAbot")
+	is.Equal(doc.Find("a.app-tag").Length(), len(DefaultTopic.Tags))
+
+	// Other topics are served at '/t/{topic}'.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/t/tps", nil))
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(gotTopic, "tps")
+	doc = goqueryDoc(w.Body)
+	is.Equal(doc.Find("header h1 a").Text(), "TPSbot")
+	is.Equal(doc.Find("#search-form").AttrOr("action", ""), "/t/tps")
+	tag := doc.Find("a.app-tag")
+	is.Equal(tag.Text(), "#haiti")
+	is.Equal(tag.AttrOr("href", ""), "/t/tps?q=Haiti")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/t/tps/feed.rss", nil))
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(gotTopic, "tps")
+
+	// Unknown topics are not found.
+	for _, path := range []string{"/t/asylum", "/t/asylum/feed.rss"} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		is.Equal(w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAPIArticlesHandler_Topic(t *testing.T) {
+	is := is.New(t)
+	setTestTopics(t, DefaultTopic, tpsTopic)
+
+	gotTopic := "unset"
+	s := newTestServer(&MockServerDB{
+		getArticlesMock: func(ctx context.Context, query ArticleQuery) (*ArticlePage, error) {
+			gotTopic = query.Topic
+			return &ArticlePage{Articles: []*Article{}}, nil
+		},
+	})
+
+	w := httptest.NewRecorder()
+	http.HandlerFunc(s.apiArticlesHandler).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/articles", nil))
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(gotTopic, "") // All the topics
+
+	w = httptest.NewRecorder()
+	http.HandlerFunc(s.apiArticlesHandler).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/articles?topic=tps", nil))
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(gotTopic, "tps")
+
+	w = httptest.NewRecorder()
+	http.HandlerFunc(s.apiArticlesHandler).ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/articles?topic=asylum", nil))
+	is.Equal(w.Code, http.StatusBadRequest)
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// InsertResult is the outcome of saving a batch of articles.
+type InsertResult struct {
+	InsertedIDs []int   // The ids of the new articles.
+	Updated     int     // Existing articles whose metadata changed.
+	Unchanged   int     // Existing articles which were already up to date.
+	Errors      []error // The articles which could not be saved.
+}
+
+// Inserted is the number of new articles.
+func (r *InsertResult) Inserted() int {
+	return len(r.InsertedIDs)
+}
+
+// Failed is the number of articles which could not be saved.
+func (r *InsertResult) Failed() int {
+	return len(r.Errors)
+}
+
+// upsertArticleSQL inserts an article, or updates the metadata of the article
+// with the same url and topic. Empty values never overwrite stored values, so that a source
+// with less metadata does not erase it. The update is skipped when nothing changed,
+// so the affected row count tells if the article was changed.
+//
+// The null-safe comparison operator differs between databases.
+func upsertArticleSQL(isDistinct string) string {
+	changed := func(column string) string {
+		return fmt.Sprintf("(excluded.%[1]v != '' AND excluded.%[1]v %[2]v article.%[1]v)", column, isDistinct)
+	}
+	merged := func(column string) string {
+		return fmt.Sprintf("%[1]v = COALESCE(NULLIF(excluded.%[1]v, ''), article.%[1]v)", column)
+	}
+
+	return `
+		INSERT INTO article (
+			"url", "canonical_url", "topic", "title", "description", "source",
+			"author", "lede_img", "published_at", "created_at"
+		)
+		VALUES (
+			:url, :canonical_url, :topic, :title, :description, :source,
+			:author, :lede_img, :published_at, :created_at
+		)
+		ON CONFLICT (url, topic) DO UPDATE SET
+			` + merged("title") + `,
+			` + merged("description") + `,
+			` + merged("author") + `,
+			` + merged("lede_img") + `
+		WHERE ` + changed("title") + `
+			OR ` + changed("description") + `
+			OR ` + changed("author") + `
+			OR ` + changed("lede_img") + `;`
+}
+
+// setArticleDefaults fills in the fields of an Article which are derived when it is saved.
+// Articles without a topic belong to the default topic.
+func setArticleDefaults(article *Article) {
+	if article.CanonicalURL == "" {
+		article.CanonicalURL = CanonicalURL(article.URL)
+	}
+	if article.Topic == "" {
+		article.Topic = GetDefaultTopic().Slug
+	}
+}
+
+// upsertArticles saves the articles in a single transaction. Each article is
+// upserted in a savepoint, so that an article which fails, for example on a
+// constraint, is counted and skipped without aborting the rest of the batch.
+// An error is returned if the transaction itself fails, and nothing is saved.
+// An article with the canonical url of a saved article of the same topic updates
+// that article. An article which matches more than one topic is saved for each topic.
+// The ids of the saved articles are set on the articles. The saved func, when
+// set, is called in the transaction with the id of each new or changed article.
+func upsertArticles(ctx context.Context, db *sqlx.DB, upsertSQL string, articles []*Article, saved func(ctx context.Context, tx *sqlx.Tx, id int) error) (*InsertResult, error) {
+	result := &InsertResult{InsertedIDs: []int{}}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not save articles: %w", err)
+	}
+	defer tx.Rollback()
+
+	findID := tx.Rebind(`SELECT id FROM article WHERE url = ? AND topic = ?;`)
+	findExisting := tx.Rebind(`
+		SELECT id, url FROM article
+		WHERE topic = ? AND (url = ? OR canonical_url = ?)
+		ORDER BY url = ? DESC
+		LIMIT 1;`)
+
+	for _, article := range articles {
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT upsert_article;`); err != nil {
+			return nil, fmt.Errorf("could not save articles: %w", err)
+		}
+
+		setArticleDefaults(article)
+
+		existing := struct {
+			ID  int    `db:"id"`
+			URL string `db:"url"`
+		}{}
+		err := tx.GetContext(ctx, &existing, findExisting, article.Topic, article.URL, article.CanonicalURL, article.URL)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("could not save articles: %w", err)
+		}
+		existingID := existing.ID
+
+		// A variant of a saved url conflicts with the saved article.
+		upserted := article
+		if existingID != 0 && existing.URL != article.URL {
+			variant := *article
+			variant.URL = existing.URL
+			upserted = &variant
+		}
+
+		var changed int64
+		res, err := tx.NamedExecContext(ctx, upsertSQL, upserted)
+		if err == nil {
+			changed, err = res.RowsAffected()
+		}
+		if err == nil && existingID == 0 {
+			err = tx.GetContext(ctx, &article.ID, findID, article.URL, article.Topic)
+		}
+		if err == nil && saved != nil && (existingID == 0 || changed > 0) {
+			id := existingID
+			if id == 0 {
+				id = article.ID
+			}
+			err = saved(ctx, tx, id)
+		}
+
+		if err != nil {
+			// The context being done is not a failure of the article.
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT upsert_article; RELEASE SAVEPOINT upsert_article;`); err != nil {
+				return nil, fmt.Errorf("could not save articles: %w", err)
+			}
+			result.Errors = append(result.Errors, fmt.Errorf("article %v: %w", article.URL, err))
+			continue
+		}
+
+		switch {
+		case existingID == 0:
+			result.InsertedIDs = append(result.InsertedIDs, article.ID)
+		case changed > 0:
+			article.ID = existingID
+			result.Updated++
+		default:
+			article.ID = existingID
+			result.Unchanged++
+		}
+
+		if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT upsert_article;`); err != nil {
+			return nil, fmt.Errorf("could not save articles: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not save articles: %w", err)
+	}
+	return result, nil
+}
@@ -3,11 +3,14 @@ module github.com/tunedmystic/dacabot
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/PuerkitoBio/goquery v1.5.1
 	github.com/gorilla/mux v1.7.4
 	github.com/integrii/flaggy v1.4.4
 	github.com/jmoiron/sqlx v1.2.0
+	github.com/lib/pq v1.10.9
 	github.com/matryer/is v1.4.0
 	github.com/mattn/go-sqlite3 v1.14.0
 	github.com/robfig/cron/v3 v3.0.1
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 )
@@ -1,23 +1,38 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/integrii/flaggy"
 	"github.com/tunedmystic/dacabot/app"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 // CmdLineOpts stores the options that are parsed.
 type CmdLineOpts struct {
+	Config   string
+	DB       string
+	Topic    string
 	Port     int
 	From     string
 	To       string
 	FromDate time.Time
 	ToDate   time.Time
+	Window   string
+	Version  int
+	Username string
 }
 
 // RunCLI parses the given args and executes the appropriate action.
@@ -25,18 +40,21 @@ func RunCLI() {
 	todayStr := time.Now().UTC().Format("2006-01-02")
 
 	opts := CmdLineOpts{
-		Port: 8000,
-		From: todayStr,
-		To:   todayStr,
+		From:    todayStr,
+		To:      todayStr,
+		Window:  "week",
+		Version: -1,
 	}
 
 	flaggy.SetName("dacabot")
 	flaggy.SetDescription("A news aggregator for DACA-related news")
+	flaggy.String(&opts.Config, "c", "config", "Config file (default: $DACABOT_CONFIG or "+app.DefaultConfigFile+")")
 
 	// The 'run-server' subcommand.
 	cmdRunServer := flaggy.NewSubcommand("run-server")
 	cmdRunServer.Description = "Start the web application"
-	cmdRunServer.Int(&opts.Port, "p", "port", "Port to run the server on")
+	cmdRunServer.Int(&opts.Port, "p", "port", "Port to run the server on (default: $PORT, the config file, or 8000)")
+	cmdRunServer.String(&opts.DB, "", "db", dbFlagHelp)
 	flaggy.AttachSubcommand(cmdRunServer, 1)
 
 	// The 'fetch-articles' subcommand.
@@ -44,8 +62,68 @@ func RunCLI() {
 	cmdFetchArticles.Description = "Fetch articles from news sources"
 	cmdFetchArticles.String(&opts.From, "f", "from", "Limit by PublishDate >=")
 	cmdFetchArticles.String(&opts.To, "t", "to", "Limit by PublishDate <=")
+	cmdFetchArticles.String(&opts.Topic, "", "topic", "The topic to fetch (default: all topics)")
+	cmdFetchArticles.String(&opts.DB, "", "db", dbFlagHelp)
 	flaggy.AttachSubcommand(cmdFetchArticles, 1)
 
+	// The 'backfill' subcommand.
+	cmdBackfill := flaggy.NewSubcommand("backfill")
+	cmdBackfill.Description = "Fetch the articles of a date range, one window at a time"
+	cmdBackfill.String(&opts.From, "f", "from", "Limit by PublishDate >=")
+	cmdBackfill.String(&opts.To, "t", "to", "Limit by PublishDate <=")
+	cmdBackfill.String(&opts.Window, "w", "window", "The size of each window: 'day' or 'week'")
+	cmdBackfill.String(&opts.Topic, "", "topic", "The topic to fetch (default: the default topic)")
+	cmdBackfill.String(&opts.DB, "", "db", dbFlagHelp)
+	flaggy.AttachSubcommand(cmdBackfill, 1)
+
+	// The 'migrate' subcommand.
+	cmdMigrate := flaggy.NewSubcommand("migrate")
+	cmdMigrate.Description = "Manage the database schema migrations"
+	cmdMigrate.String(&opts.DB, "", "db", dbFlagHelp)
+	cmdMigrateUp := flaggy.NewSubcommand("up")
+	cmdMigrateUp.Description = "Apply all pending migrations"
+	cmdMigrate.AttachSubcommand(cmdMigrateUp, 1)
+	cmdMigrateStatus := flaggy.NewSubcommand("status")
+	cmdMigrateStatus.Description = "List the migrations and whether they are applied"
+	cmdMigrate.AttachSubcommand(cmdMigrateStatus, 1)
+	cmdMigrateDown := flaggy.NewSubcommand("down")
+	cmdMigrateDown.Description = "Revert migrations down to a version"
+	cmdMigrateDown.Int(&opts.Version, "t", "to", "The version to revert to")
+	cmdMigrate.AttachSubcommand(cmdMigrateDown, 1)
+	flaggy.AttachSubcommand(cmdMigrate, 1)
+
+	// The 'jobs' subcommand.
+	cmdJobs := flaggy.NewSubcommand("jobs")
+	cmdJobs.Description = "Inspect the scheduled jobs"
+	cmdJobsList := flaggy.NewSubcommand("list")
+	cmdJobsList.Description = "List the jobs, their schedules and their next runs"
+	cmdJobs.AttachSubcommand(cmdJobsList, 1)
+	flaggy.AttachSubcommand(cmdJobs, 1)
+
+	// The 'users' subcommand.
+	cmdUsers := flaggy.NewSubcommand("users")
+	cmdUsers.Description = "Manage the admin users"
+	cmdUsers.String(&opts.DB, "", "db", dbFlagHelp)
+	cmdUsersCreate := flaggy.NewSubcommand("create")
+	cmdUsersCreate.Description = "Create an admin user. The password is read from the terminal, or from stdin"
+	cmdUsersCreate.String(&opts.Username, "u", "username", "The username of the admin")
+	cmdUsers.AttachSubcommand(cmdUsersCreate, 1)
+	cmdUsersPassword := flaggy.NewSubcommand("password")
+	cmdUsersPassword.Description = "Change the password of an admin user, and sign them out everywhere"
+	cmdUsersPassword.String(&opts.Username, "u", "username", "The username of the admin")
+	cmdUsers.AttachSubcommand(cmdUsersPassword, 1)
+	flaggy.AttachSubcommand(cmdUsers, 1)
+
+	// The 'config' subcommand.
+	cmdConfig := flaggy.NewSubcommand("config")
+	cmdConfig.Description = "Inspect the configuration"
+	cmdConfig.String(&opts.DB, "", "db", dbFlagHelp)
+	cmdConfig.Int(&opts.Port, "p", "port", "Port to run the server on")
+	cmdConfigShow := flaggy.NewSubcommand("show")
+	cmdConfigShow.Description = "Print the effective configuration, with its secrets redacted"
+	cmdConfig.AttachSubcommand(cmdConfigShow, 1)
+	flaggy.AttachSubcommand(cmdConfig, 1)
+
 	flaggy.Parse()
 
 	if len(os.Args) < 2 {
@@ -53,16 +131,29 @@ func RunCLI() {
 		return
 	}
 
+	config := mustLoadConfig(opts)
+
 	if cmdRunServer.Used {
 		// Setup server.
-		server := app.NewServer()
+		server := app.NewServer(config.Database.DSN)
 		defer server.Cleanup()
+		server.Sessions = app.NewSessionStore(config.Admin.SessionSecret)
+		server.StaleSyncAdvisory = config.Server.StaleSyncAdvisory
+		server.ClientIPHeader = config.Server.ClientIPHeader
+		if config.Admin.SessionSecret == "" {
+			fmt.Println("[run] no session secret is configured, the admins will be signed out when the server restarts")
+		}
 
 		// Setup periodic tasks.
-		app.SetupTasks()
+		scheduler, err := app.SetupTasks(server.DB)
+		if err != nil {
+			log.Fatalf("[run] %v\n", err)
+		}
+		defer scheduler.Stop()
+		server.Scheduler = scheduler
 
 		// Run server.
-		addr := fmt.Sprintf("0.0.0.0:%v", opts.Port)
+		addr := fmt.Sprintf("0.0.0.0:%v", config.Server.Port)
 		fmt.Printf("[run] starting Server on %v...\n", addr)
 		log.Fatal(http.ListenAndServe(addr, server.Router))
 	}
@@ -71,8 +162,317 @@ func RunCLI() {
 		opts.FromDate = app.MustParseDate(opts.From)
 		opts.ToDate = app.MustParseDate(opts.To)
 
+		db := mustOpenDB(config.Database.DSN)
+		defer db.Close()
+		if err := db.Migrate(); err != nil {
+			log.Fatalf("[fetch-articles] %v\n", err)
+		}
+
 		// Fetch articles.
-		app.UpdateArticles(opts.FromDate, opts.ToDate, true)
+		topics := app.GetTopics()
+		if opts.Topic != "" {
+			topics = []*app.Topic{mustGetTopic(opts.Topic)}
+		}
+		failed := false
+		for _, topic := range topics {
+			_, err := app.UpdateArticles(context.Background(), db, topic, opts.FromDate, opts.ToDate, true)
+			if errors.Is(err, app.ErrTaskLocked) {
+				fmt.Printf("[fetch-articles] %v: skipped, %v\n", topic.Slug, err)
+				continue
+			}
+			if err != nil {
+				fmt.Printf("[fetch-articles] %v: %v\n", topic.Slug, err)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	}
+
+	if cmdBackfill.Used {
+		opts.FromDate = app.MustParseDate(opts.From)
+		opts.ToDate = app.MustParseDate(opts.To)
+
+		db := mustOpenDB(config.Database.DSN)
+		defer db.Close()
+		if err := db.Migrate(); err != nil {
+			log.Fatalf("[backfill] %v\n", err)
+		}
+
+		runBackfill(db, opts)
+	}
+
+	if cmdMigrate.Used {
+		db := mustOpenDB(config.Database.DSN)
+		defer db.Close()
+
+		switch {
+		case cmdMigrateUp.Used:
+			runMigrateUp(db)
+		case cmdMigrateDown.Used:
+			runMigrateDown(db, opts.Version)
+		case cmdMigrateStatus.Used:
+			runMigrateStatus(db)
+		default:
+			flaggy.ShowHelp("")
+		}
+	}
+
+	if cmdJobs.Used {
+		switch {
+		case cmdJobsList.Used:
+			runJobsList()
+		default:
+			flaggy.ShowHelp("")
+		}
+	}
+
+	if cmdUsers.Used {
+		db := mustOpenDB(config.Database.DSN)
+		defer db.Close()
+		if err := db.Migrate(); err != nil {
+			log.Fatalf("[users] %v\n", err)
+		}
+
+		switch {
+		case cmdUsersCreate.Used:
+			runUsersCreate(db, opts.Username)
+		case cmdUsersPassword.Used:
+			runUsersPassword(db, opts.Username)
+		default:
+			flaggy.ShowHelp("")
+		}
+	}
+
+	if cmdConfig.Used {
+		switch {
+		case cmdConfigShow.Used:
+			if err := config.Write(os.Stdout); err != nil {
+				log.Fatalf("[config] %v\n", err)
+			}
+		default:
+			flaggy.ShowHelp("")
+		}
+	}
+}
+
+// mustLoadConfig reads the config file, applies the env vars and the flags over it,
+// and configures the app. It exits if the configuration is invalid.
+func mustLoadConfig(opts CmdLineOpts) *app.Config {
+	config, err := app.LoadConfig(opts.Config)
+	if err != nil {
+		log.Fatalf("Could not load the config: %v\n", err)
+	}
+	if err := config.ApplyEnv(); err != nil {
+		log.Fatalf("Could not load the config: %v\n", err)
+	}
+
+	if opts.DB != "" {
+		config.Database.DSN = opts.DB
+	}
+	if opts.Port != 0 {
+		config.Server.Port = opts.Port
+	}
+
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Could not load the config: %v\n", err)
+	}
+	if err := config.Apply(); err != nil {
+		log.Fatalf("Could not load the config: %v\n", err)
+	}
+	return config
+}
+
+// dbFlagHelp is the help text of the '--db' flag.
+const dbFlagHelp = "Database DSN (default: $DATABASE_URL, the config file, or " + app.DefaultDSN + ")"
+
+// mustOpenDB opens the database, and exits if it cannot be opened.
+func mustOpenDB(dsn string) app.Database {
+	db, err := app.NewDB(dsn)
+	if err != nil {
+		log.Fatalf("Could not open the database: %v\n", err)
+	}
+	return db
+}
+
+// mustGetTopic returns the topic with the slug, and exits if it is not configured.
+func mustGetTopic(slug string) *app.Topic {
+	topic, ok := app.GetTopic(slug)
+	if !ok {
+		log.Fatalf("Unknown topic %q\n", slug)
+	}
+	return topic
+}
+
+// migrator returns the migrations interface of the database.
+func migrator(db app.Database) app.Migrator {
+	m, ok := db.(app.Migrator)
+	if !ok {
+		log.Fatalf("[migrate] %T does not support migrations\n", db)
+	}
+	return m
+}
+
+func runMigrateUp(db app.Database) {
+	migrated, err := migrator(db).MigrateUp()
+	if err != nil {
+		log.Fatalf("[migrate] %v\n", err)
+	}
+	fmt.Printf("[migrate] applied %v migrations\n", len(migrated))
+}
+
+func runMigrateDown(db app.Database, version int) {
+	if version < 0 {
+		log.Fatal("[migrate] the version to revert to is required, ex: 'migrate down --to 1'")
+	}
+	migrated, err := migrator(db).MigrateDown(version)
+	if err != nil {
+		log.Fatalf("[migrate] %v\n", err)
+	}
+	fmt.Printf("[migrate] reverted %v migrations\n", len(migrated))
+}
+
+func runMigrateStatus(db app.Database) {
+	statuses, err := migrator(db).MigrationStatus()
+	if err != nil {
+		log.Fatalf("[migrate] %v\n", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.Applied() {
+			appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", status.Version, status.Name, appliedAt)
+	}
+	w.Flush()
+}
+
+func runJobsList() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSCHEDULE\tTIMEOUT\tLOOKBACK\tNEXT RUN\tDESCRIPTION")
+	// The jobs are not running, so their next runs are computed from the schedules.
+	for _, status := range app.Jobs() {
+		job := status.Job
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v days\t%v\t%v\n",
+			job.Name, job.Schedule, job.Timeout, job.LookbackDays,
+			status.NextRun.Format("2006-01-02 15:04:05"), job.Description)
+	}
+	w.Flush()
+}
+
+func runUsersCreate(db app.Database, username string) {
+	if username == "" {
+		log.Fatal("[users] the username is required, ex: 'users create --username alice'")
+	}
+
+	password := readPassword("Password: ")
+	if terminal.IsTerminal(int(os.Stdin.Fd())) && readPassword("Confirm password: ") != password {
+		log.Fatal("[users] the passwords do not match")
+	}
+
+	user, err := app.CreateUser(context.Background(), db, username, password)
+	if err != nil {
+		log.Fatalf("[users] %v\n", err)
+	}
+	fmt.Printf("[users] created admin user %v\n", user.Username)
+}
+
+func runUsersPassword(db app.Database, username string) {
+	if username == "" {
+		log.Fatal("[users] the username is required, ex: 'users password --username alice'")
+	}
+
+	password := readPassword("New password: ")
+	if terminal.IsTerminal(int(os.Stdin.Fd())) && readPassword("Confirm password: ") != password {
+		log.Fatal("[users] the passwords do not match")
+	}
+
+	if err := app.ChangePassword(context.Background(), db, username, password); err != nil {
+		log.Fatalf("[users] %v\n", err)
+	}
+	fmt.Printf("[users] changed the password of admin user %v\n", username)
+}
+
+// readPassword reads a password without echoing it when stdin is a terminal,
+// or reads a line of stdin, ex: 'echo "$PASSWORD" | dacabot users create -u alice'.
+func readPassword(prompt string) string {
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		fmt.Print(prompt)
+		password, err := terminal.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			log.Fatalf("[users] could not read the password: %v\n", err)
+		}
+		return string(password)
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Fatalf("[users] could not read the password: %v\n", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// backfillWindows are the window sizes of the 'backfill' subcommand.
+var backfillWindows = map[string]int{
+	"day":  app.BackfillDay,
+	"week": app.BackfillWeek,
+}
+
+func runBackfill(db app.Database, opts CmdLineOpts) {
+	days, ok := backfillWindows[opts.Window]
+	if !ok {
+		log.Fatalf("[backfill] invalid window %q, expected 'day' or 'week'\n", opts.Window)
+	}
+	if opts.FromDate.After(opts.ToDate) {
+		log.Fatal("[backfill] the 'from' date is after the 'to' date")
+	}
+
+	// Stop after an interrupt, so that the completed windows are recorded.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		fmt.Println("[backfill] interrupted, stopping...")
+		cancel()
+	}()
+
+	topic := app.GetDefaultTopic()
+	if opts.Topic != "" {
+		topic = mustGetTopic(opts.Topic)
+	}
+
+	windows, err := app.Backfill(ctx, db, topic.GetSources(), topic, opts.FromDate, opts.ToDate, days)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "FROM\tTO\tSTATUS\tFETCHED\tINSERTED\tUPDATED\tFAILED\tERROR")
+	failed := 0
+	for _, window := range windows {
+		status := window.Status
+		if window.Skipped {
+			status = "done"
+		} else if window.Status != app.TaskStatusSuccess {
+			failed++
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			window.From.Format("2006-01-02"), window.To.Format("2006-01-02"), status,
+			window.Fetched, window.Inserted, window.Updated, window.Failed, window.Error)
+	}
+	w.Flush()
+
+	if err != nil {
+		log.Fatalf("[backfill] stopped after %v windows: %v\n", len(windows), err)
+	}
+	if failed > 0 {
+		log.Fatalf("[backfill] %v windows failed or were skipped, run the backfill again to retry them\n", failed)
 	}
 }
 